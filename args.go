@@ -0,0 +1,164 @@
+package flagrouter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ExpandResponseFiles sets whether args start with `@` are response files,
+// default false. If enabled, Run replaces `@file` by args read from file,
+// which are split by whitespaces and newlines, and can be quoted like shell.
+// Response files can be nested, relative paths are relative to
+// the working directory. Args after a bare `--`, given or read from a file,
+// are not expanded, so `app -- @user` passes `@user` as is.
+func (r *Router) ExpandResponseFiles(enable bool) {
+	r.responseFiles = enable
+}
+
+// ReadArgsFromStdin sets whether a standalone arg `-` is replaced by args read
+// from stdin, default false. Args are split like response files, see
+// ExpandResponseFiles, which are expanded after args from stdin. Stdin can be
+// read only once in a run, and `-` after a bare `--` is not replaced.
+// Flags tagged `fromstdin:"true"` should be written as `--file=-` then.
+func (r *Router) ReadArgsFromStdin(enable bool) {
+	r.stdinArgs = enable
+}
+
+// expandStdinArgs replaces `-` in args by args read from rd,
+// and returns rd, or nil if it has been read.
+func expandStdinArgs(args []string, rd io.Reader) ([]string, io.Reader, error) {
+	expanded := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(expanded, args[i:]...), rd, nil
+		}
+		if arg != "-" {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		if rd == nil {
+			return nil, nil, errors.New("flagrouter: args from stdin: stdin has been read")
+		}
+		data, err := io.ReadAll(rd)
+		if err != nil {
+			return nil, nil, fmt.Errorf("flagrouter: args from stdin: %w", err)
+		}
+		rd = nil
+		list, err := SplitArgs(string(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("flagrouter: args from stdin: %w", err)
+		}
+		expanded = append(expanded, list...)
+	}
+	return expanded, rd, nil
+}
+
+// maxResponseFileDepth limits nested response files, avoids infinite recursion.
+const maxResponseFileDepth = 10
+
+func expandResponseFiles(args []string, depth int) ([]string, error) {
+	expanded := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(expanded, args[i:]...), nil
+		}
+		if len(arg) < 2 || arg[0] != '@' {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		path := arg[1:]
+		if depth >= maxResponseFileDepth {
+			return nil, fmt.Errorf("flagrouter: response file %v: nested too deep", path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("flagrouter: response file: %w", err)
+		}
+		list, err := SplitArgs(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("flagrouter: response file %v: %w", path, err)
+		}
+		list, err = expandResponseFiles(list, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, list...)
+		// a `--` read from the file ends expansion too
+		if slices.Contains(list, "--") {
+			return append(expanded, args[i+1:]...), nil
+		}
+	}
+	return expanded, nil
+}
+
+// SplitArgs splits s into args like a POSIX shell, without expansions:
+// args are separated by whitespaces, single quotes preserve every char
+// inside them, double quotes preserve chars except escaped `"`, `\`, `$` and backquote,
+// and backslash outside quotes escapes the next char. Unbalanced quotes are errors.
+// Response files and args from stdin are split by it.
+func SplitArgs(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+
+		case c == '\\':
+			inArg = true
+			if i+1 < len(s) {
+				i++
+				if s[i] != '\n' {
+					arg.WriteByte(s[i])
+				}
+			}
+
+		case c == '\'':
+			inArg = true
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unbalanced single quote")
+			}
+			arg.WriteString(s[i+1 : i+1+end])
+			i += 1 + end
+
+		case c == '"':
+			inArg = true
+			closed := false
+			for i++; i < len(s); i++ {
+				if s[i] == '"' {
+					closed = true
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				}
+				arg.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, errors.New("unbalanced double quote")
+			}
+
+		default:
+			inArg = true
+			arg.WriteByte(c)
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
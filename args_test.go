@@ -0,0 +1,191 @@
+package flagrouter
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseFiles(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "nested.txt")
+	flat := filepath.Join(dir, "flat.txt")
+	if err := os.WriteFile(nested, []byte("--list 8\n--str 'hello world'\n"), 0o644); err != nil {
+		t.Fatalf("write response file: %v", err)
+	}
+	if err := os.WriteFile(flat, []byte("-i 456\n--list 7 @"+nested+"\n"), 0o644); err != nil {
+		t.Fatalf("write response file: %v", err)
+	}
+
+	var opt *options
+	r := New("response", "")
+	r.ExpandResponseFiles(true)
+	r.HandleGroup("sub", "", func(o *options) { opt = o })
+
+	_, err := r.Run(context.Background(), "sub", "@"+flat)
+	if err != nil {
+		t.Fatalf("response file run: %v", err)
+	}
+	if opt.Int != 456 || !reflect.DeepEqual(opt.List, []int{7, 8}) || opt.Str != "hello world" {
+		t.Fatalf("response file: options: %+v", *opt)
+	}
+
+	_, err = r.Run(context.Background(), "sub", "@"+filepath.Join(dir, "missing.txt"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("response file: missing file: %v", err)
+	}
+
+	// args after a bare `--` are never response files
+	dash := filepath.Join(dir, "dash.txt")
+	if err := os.WriteFile(dash, []byte("a --\n"), 0o644); err != nil {
+		t.Fatalf("write response file: %v", err)
+	}
+	var got []string
+	r.HandleGroup("args", "", func(o *struct{ Args []string }) { got = o.Args })
+	for _, c := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"args", "--", "@user"}, []string{"@user"}},
+		{[]string{"args", "@" + dash, "@user"}, []string{"a", "@user"}},
+	} {
+		if _, err = r.Run(context.Background(), c.args...); err != nil {
+			t.Fatalf("response file: run %q: %v", c.args, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("response file: run %q: %q", c.args, got)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := SplitArgs(`a "b c" 'd "e"' f\ g "h\"i" j\` + "\n" + `k`)
+	if err != nil {
+		t.Fatalf("split args: %v", err)
+	}
+	want := []string{"a", "b c", `d "e"`, "f g", `h"i`, "jk"}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("split args: %q", args)
+	}
+
+	args, err = SplitArgs(` --name="x y"  'it'\''s' "a\b" \"q\" '' ` + "\t\n")
+	if err != nil {
+		t.Fatalf("split args: %v", err)
+	}
+	want = []string{"--name=x y", "it's", `a\b`, `"q"`, ""}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("split args: %q", args)
+	}
+
+	for _, s := range []string{`"a`, `'a`, `"a\"`, `a 'b"`} {
+		if _, err = SplitArgs(s); err == nil {
+			t.Fatalf("split args %v: no error", s)
+		}
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	type fromFile struct {
+		Token string        `long:"token" fromfile:"true"`
+		Port  int           `long:"port" fromfile:"true"`
+		Wait  time.Duration `long:"wait" fromfile:"true"`
+		Raw   string        `long:"raw"`
+	}
+
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	portFile := filepath.Join(dir, "port")
+	os.WriteFile(tokenFile, []byte("  s3cret\n"), 0o600)
+	os.WriteFile(portFile, []byte("8080\n"), 0o600)
+
+	var opt *fromFile
+	r := New("fromfile", "")
+	r.Handle(func(o *fromFile) { opt = o })
+
+	_, err := r.Run(context.Background(), "--token", "@"+tokenFile, "--port=@"+portFile, "--wait", "1s", "--raw", "@"+tokenFile)
+	if err != nil {
+		t.Fatalf("fromfile: run: %v", err)
+	}
+	want := fromFile{Token: "s3cret", Port: 8080, Wait: time.Second, Raw: "@" + tokenFile}
+	if *opt != want {
+		t.Fatalf("fromfile: %+v, want %+v", *opt, want)
+	}
+
+	_, err = r.Run(context.Background(), "--token", "@"+filepath.Join(dir, "missing"))
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "parse option --token") {
+		t.Fatalf("fromfile: missing file: %v", err)
+	}
+}
+
+func TestFromStdin(t *testing.T) {
+	type fromStdin struct {
+		Token string `long:"token" fromstdin:"true"`
+		Text  string `long:"text" fromstdin:"true" fromfile:"true"`
+		Files []string
+	}
+
+	var opt *fromStdin
+	r := New("fromstdin", "")
+	r.Handle(func(o *fromStdin) { opt = o })
+
+	r.SetInput(strings.NewReader(" s3cret\n"))
+	_, err := r.Run(context.Background(), "--token", "-", "-", "a")
+	if err != nil {
+		t.Fatalf("fromstdin: run: %v", err)
+	}
+	want := fromStdin{Token: "s3cret", Files: []string{"-", "a"}}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("fromstdin: %+v, want %+v", *opt, want)
+	}
+
+	r.SetInput(strings.NewReader("text"))
+	_, err = r.Run(context.Background(), "--token", "-", "--text=-")
+	if err == nil || !strings.Contains(err.Error(), "parse option --text=-: stdin has been read") {
+		t.Fatalf("fromstdin: read twice: %v", err)
+	}
+}
+
+func TestReadArgsFromStdin(t *testing.T) {
+	type stdinArgs struct {
+		Int   int    `short:"i"`
+		Str   string `long:"str"`
+		Files []string
+	}
+
+	var got stdinArgs
+	r := New("stdin", "")
+	r.HandleGroup("sub", "", func(opt stdinArgs) { got = opt })
+
+	r.SetInput(strings.NewReader("-i 1\n--str 'hello world' \"b c\"\n"))
+	if _, err := r.Run(context.Background(), "sub", "-", "a"); err != nil {
+		t.Fatalf("args from stdin: disabled: %v", err)
+	}
+	if !reflect.DeepEqual(got.Files, []string{"-", "a"}) {
+		t.Fatalf("args from stdin: disabled: %+v", got)
+	}
+
+	r.ReadArgsFromStdin(true)
+	r.SetInput(strings.NewReader("-i 1\n--str 'hello world' \"b c\"\n"))
+	if _, err := r.Run(context.Background(), "sub", "a", "-", "d", "--", "-"); err != nil {
+		t.Fatalf("args from stdin: run: %v", err)
+	}
+	want := stdinArgs{Int: 1, Str: "hello world", Files: []string{"a", "b c", "d", "-"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("args from stdin: %+v", got)
+	}
+
+	r.SetInput(strings.NewReader("a"))
+	if _, err := r.Run(context.Background(), "sub", "-", "-"); err == nil {
+		t.Fatalf("args from stdin: read twice: expect error")
+	}
+	r.SetInput(strings.NewReader("'a"))
+	if _, err := r.Run(context.Background(), "sub", "-"); err == nil {
+		t.Fatalf("args from stdin: unbalanced quote: expect error")
+	}
+}
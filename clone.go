@@ -0,0 +1,67 @@
+package flagrouter
+
+// op is a registration replayed by Clone,
+// nodes maps nodes of the router to nodes of the copy.
+type op func(c *Router, nodes map[*node]*node)
+
+// record records registration fn to current node,
+// Clone replays it to the same node of the copy.
+func (r *Router) record(fn func(c *Router)) {
+	n := r.cur
+	r.ops = append(r.ops, func(c *Router, nodes map[*node]*node) {
+		c.cur = nodes[n]
+		fn(c)
+	})
+}
+
+// openCmd opens subcommand name of current node.
+func (r *Router) openCmd(name, desc string, aliases []string) *node {
+	n := r.cur
+	s := n.cmd(name, desc, aliases...)
+	r.ops = append(r.ops, func(c *Router, nodes map[*node]*node) {
+		c.cur = nodes[n]
+		nodes[s] = c.openCmd(name, desc, aliases)
+	})
+	return s
+}
+
+// openStmt opens a statement of current node.
+func (r *Router) openStmt() *node {
+	n := r.cur
+	s := n.stmt()
+	r.ops = append(r.ops, func(c *Router, nodes map[*node]*node) {
+		c.cur = nodes[n]
+		nodes[s] = c.openStmt()
+	})
+	return s
+}
+
+// Clone returns a copy of r, with all cmds, flags, middlewares, handlers
+// and settings registered to r. The copy and r can be extended independently.
+// Flags of the copy are bound to new option structs, so runs of the copy and r
+// never share values. Loaded config is shared, which is never modified.
+func (r *Router) Clone() *Router {
+	c := New(r.root.name, r.root.desc)
+	nodes := map[*node]*node{r.root: c.root}
+	for _, op := range r.ops {
+		op(c, nodes)
+	}
+	c.cur = nodes[r.cur]
+
+	c.config = r.config
+	c.strictConfig = r.strictConfig
+	c.dumpSecrets = r.dumpSecrets
+	c.outw, c.errw, c.in = r.outw, r.errw, r.in
+	c.translator = r.translator
+	c.responseFiles = r.responseFiles
+	c.stdinArgs = r.stdinArgs
+	c.interspersed = r.interspersed
+	c.prefixMatch = r.prefixMatch
+	c.passthrough = r.passthrough
+	c.autoShort = r.autoShort
+	c.stopOnCancel = r.stopOnCancel
+	c.recoverPanics = r.recoverPanics
+	c.envPrefix = r.envPrefix
+	c.signals = r.signals
+	return c
+}
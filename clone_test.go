@@ -0,0 +1,65 @@
+package flagrouter
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	var calls []string
+	base := New("tool", "the tool")
+	base.Use(func() { calls = append(calls, "mw") })
+	base.Group("db", "database", func() {
+		base.SetLongDesc("manage the database")
+		base.Handle(func(opt *struct {
+			DSN string `long:"dsn" dft:"local"`
+		}) {
+			calls = append(calls, "db "+opt.DSN)
+		})
+	})
+
+	variant := base.Clone()
+	variant.HandleGroup("extra", "only in variant", func() { calls = append(calls, "extra") })
+	variant.Use(func(opt *struct {
+		Verbose bool `short:"v" persistent:"true"`
+	}) {
+	})
+	base.HandleGroup("more", "only in base", func() { calls = append(calls, "more") })
+
+	ctx := context.Background()
+	if _, err := variant.Run(ctx, "db", "--dsn", "remote", "-v"); err != nil {
+		t.Fatalf("clone: run variant: %v", err)
+	}
+	if _, err := base.Run(ctx, "db"); err != nil {
+		t.Fatalf("clone: run base: %v", err)
+	}
+	if _, err := variant.Run(ctx, "extra"); err != nil {
+		t.Fatalf("clone: run variant extra: %v", err)
+	}
+	if want := []string{"mw", "db remote", "mw", "db local", "mw", "extra"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("clone: calls: %q", calls)
+	}
+
+	if _, err := base.Run(ctx, "db", "-v"); err == nil {
+		t.Fatalf("clone: flag of variant registered to base")
+	}
+	if _, err := variant.Run(ctx, "more"); err == nil {
+		t.Fatalf("clone: cmd of base registered to variant")
+	}
+
+	usage, _ := base.Run(ctx, "-h")
+	if strings.Contains(usage, "extra") || !strings.Contains(usage, "more") {
+		t.Fatalf("clone: base usage:\n%v", usage)
+	}
+	usage, _ = variant.Run(ctx, "db", "-h")
+	if !strings.Contains(usage, "\nmanage the database\n") || !strings.Contains(usage, "-v bool") {
+		t.Fatalf("clone: variant usage:\n%v", usage)
+	}
+
+	// clones of clones
+	if _, err := variant.Clone().Run(ctx, "extra"); err != nil {
+		t.Fatalf("clone: run clone of variant: %v", err)
+	}
+}
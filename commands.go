@@ -0,0 +1,154 @@
+package flagrouter
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Commands registers cmds, a struct or a pointer to struct, as subcommands of
+// current group, built by Group and Handle. A field of cmds is a subcommand
+// if it is tagged `cmd:"name"`, or its type is a struct or a pointer to struct
+// with a Run method, named after the field in lower case then. Tags `desc`,
+// `long_desc`, `aliases` (comma separated) and `deprecated` of the field
+// describe the subcommand.
+//
+// The type of a subcommand field defines the subcommand the same way:
+//   - if it has subcommand fields, it is a group of them, and its Run method,
+//     if any, is the handler of the group itself, like HandleGroup;
+//   - otherwise it is a leaf command, which must have a Run method.
+//
+// Other fields are options of the Run method, which is called on a fresh copy
+// holding the parsed options. Run can receive and return what handlers do,
+// except the options themselves, like `Run(ctx context.Context) error`.
+// A group without Run method cannot have options. Run of cmds itself, if any,
+// is the handler of current group. Values of fields are ignored, use tag dft.
+//
+//	type App struct {
+//		Deploy deployCmd `cmd:"deploy" desc:"deploy the app"`
+//		DB     struct {
+//			Migrate migrateCmd `cmd:"migrate" desc:"run migrations"`
+//		} `cmd:"db" desc:"database commands"`
+//	}
+//
+//	type deployCmd struct {
+//		Env string `long:"env" dft:"dev"`
+//	}
+//
+//	func (c *deployCmd) Run(ctx context.Context) error { ... }
+func (r *Router) Commands(cmds any) {
+	typ := reflect.TypeOf(cmds)
+	if typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		panic(fmt.Errorf("flagrouter: commands must be a struct or a pointer to struct, got %v", typ))
+	}
+	r.commands(typ)
+}
+
+// commands registers subcommand fields of typ, and its Run method as the
+// handler of current group.
+func (r *Router) commands(typ reflect.Type) {
+	options, cmds := false, 0
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if !isCommand(field) {
+			if skip, _ := skipField(field); !skip {
+				options = true
+			}
+			continue
+		}
+		name := field.Tag.Get("cmd")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		var aliases []string
+		if tag := field.Tag.Get("aliases"); tag != "" {
+			aliases = strings.Split(tag, ",")
+		}
+		sub := field.Type
+		if sub.Kind() == reflect.Pointer {
+			sub = sub.Elem()
+		}
+		if sub.Kind() != reflect.Struct {
+			panic(fmt.Errorf("flagrouter: command field %v of %v must be a struct or a pointer to struct", field.Name, typ))
+		}
+
+		cmds++
+		r.GroupAlias(name, field.Tag.Get("desc"), aliases, func() {
+			if desc := field.Tag.Get("long_desc"); desc != "" {
+				r.SetLongDesc(desc)
+			}
+			if msg := field.Tag.Get("deprecated"); msg != "" {
+				r.DeprecateCommand(msg)
+			}
+			r.commands(sub)
+		})
+	}
+
+	run, ok := runMethod(typ)
+	if !ok {
+		if options {
+			panic(fmt.Errorf("flagrouter: %v has options but no Run method", typ))
+		}
+		if cmds == 0 {
+			panic(fmt.Errorf("flagrouter: %v has neither commands nor Run method", typ))
+		}
+		return
+	}
+	r.Handle(run)
+}
+
+// isCommand reports whether field is a subcommand of Commands: tagged `cmd`,
+// or of a struct (pointer) type with a Run method. It is never a flag.
+func isCommand(field reflect.StructField) bool {
+	if _, ok := field.Tag.Lookup("cmd"); ok {
+		return true
+	}
+	typ := field.Type
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return false
+	}
+	_, ok := reflect.PointerTo(typ).MethodByName("Run")
+	return ok
+}
+
+// runMethod returns a handler calls method Run of *typ, which receives
+// *typ as options after context.Context, if any.
+func runMethod(typ reflect.Type) (any, bool) {
+	method, ok := reflect.PointerTo(typ).MethodByName("Run")
+	if !ok {
+		return nil, false
+	}
+
+	// method.Type is like func(*typ, context.Context, ...) error,
+	// and the handler is like func(context.Context, *typ, ...) error
+	mt := method.Type
+	in := make([]reflect.Type, mt.NumIn())
+	for i := range in {
+		in[i] = mt.In(i)
+	}
+	withCtx := len(in) > 1 && in[1] == typContext
+	if withCtx {
+		in[0], in[1] = in[1], in[0]
+	}
+	out := make([]reflect.Type, mt.NumOut())
+	for i := range out {
+		out[i] = mt.Out(i)
+	}
+
+	fn := reflect.MakeFunc(reflect.FuncOf(in, out, false), func(args []reflect.Value) []reflect.Value {
+		if withCtx {
+			args[0], args[1] = args[1], args[0]
+		}
+		return method.Func.Call(args)
+	})
+	return fn.Interface(), true
+}
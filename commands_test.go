@@ -0,0 +1,109 @@
+package flagrouter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var cmdsRun []string
+
+type deployCmd struct {
+	Env    string `long:"env" dft:"dev"`
+	Target string
+}
+
+func (c *deployCmd) Run(ctx context.Context) error {
+	if ctx == nil {
+		return errors.New("nil context")
+	}
+	cmdsRun = append(cmdsRun, "deploy "+c.Env+" "+c.Target)
+	return nil
+}
+
+type migrateCmd struct {
+	Steps int `long:"steps" dft:"1"`
+}
+
+func (c migrateCmd) Run() { cmdsRun = append(cmdsRun, fmt.Sprint("migrate ", c.Steps)) }
+
+type dbCmd struct {
+	Verbose bool        `short:"v"`
+	Migrate migrateCmd  `desc:"run migrations"`
+	Seed    *migrateCmd `cmd:"seed" aliases:"s" deprecated:"use migrate"`
+}
+
+func (c *dbCmd) Run() { cmdsRun = append(cmdsRun, fmt.Sprint("db ", c.Verbose)) }
+
+type appCmds struct {
+	Deploy deployCmd `cmd:"deploy" desc:"deploy the app" long_desc:"deploy the app to an environment"`
+	DB     dbCmd     `cmd:"db" desc:"database commands"`
+	Tools  struct {
+		Lint migrateCmd `cmd:"lint"`
+	} `cmd:"tools"`
+	name string
+}
+
+func TestCommands(t *testing.T) {
+	r := New("app", "")
+	r.SetOutput(&strings.Builder{})
+	r.Commands(&appCmds{})
+
+	cmdsRun = nil
+	for _, args := range [][]string{
+		{"deploy", "--env", "prod", "web"},
+		{"db", "-v"},
+		{"db", "migrate", "--steps", "3"},
+		{"db", "s"},
+		{"tools", "lint"},
+	} {
+		if _, err := r.Run(context.Background(), args...); err != nil {
+			t.Fatalf("commands: run %q: %v", args, err)
+		}
+	}
+	want := "deploy prod web,db true,migrate 3,migrate 1,migrate 1"
+	if got := strings.Join(cmdsRun, ","); got != want {
+		t.Fatalf("commands: run: %q", got)
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, "deploy the app") || !strings.Contains(usage, "database commands") {
+		t.Fatalf("commands: usage:\n%v", usage)
+	}
+	usage, _ = r.Run(context.Background(), "deploy", "-h")
+	if !strings.Contains(usage, "deploy the app to an environment") {
+		t.Fatalf("commands: deploy usage:\n%v", usage)
+	}
+	if _, err := r.Run(context.Background(), "tools"); !errors.Is(err, ErrNoExecFunc) {
+		t.Fatalf("commands: run group without Run: %v", err)
+	}
+
+	for _, c := range []struct {
+		cmds any
+		err  string
+	}{
+		{1, "must be a struct"},
+		{&struct{}{}, "neither commands nor Run method"},
+		{&struct {
+			A struct{} `cmd:"a"`
+		}{}, "neither commands nor Run method"},
+		{&struct {
+			A string `cmd:"a"`
+		}{}, "must be a struct or a pointer to struct"},
+		{&struct {
+			N int `long:"n"`
+			A migrateCmd
+		}{}, "has options but no Run method"},
+	} {
+		func() {
+			defer func() {
+				if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), c.err) {
+					t.Fatalf("commands: expect panic %q, got %v", c.err, e)
+				}
+			}()
+			New("app", "").Commands(c.cmds)
+		}()
+	}
+}
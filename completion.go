@@ -0,0 +1,114 @@
+package flagrouter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eachain/flags"
+)
+
+// PowerShellCompletion returns a PowerShell script which completes
+// subcommands and flags of progName, walking the whole command tree.
+// Print it by a subcommand, say completion, and load it in the PowerShell
+// profile, like:
+//
+//	app completion | Out-String | Invoke-Expression
+//
+// Deprecated commands and flags are not completed, but still walked.
+func (r *Router) PowerShellCompletion(progName string) string {
+	var commands, aliases strings.Builder
+	r.root.psCommands(&commands, &aliases, progName)
+	return fmt.Sprintf(psCompletion, psQuote(progName), commands.String(), aliases.String())
+}
+
+// psCommands writes candidates of n and its subcommands keyed by their
+// command path, and aliases of subcommands to their names.
+func (n *node) psCommands(commands, aliases *strings.Builder, path string) {
+	fmt.Fprintf(commands, "    $commands[%v] = @(\n", psQuote(path))
+	for _, c := range n.cmds {
+		if c.deprecated == "" {
+			psCandidate(commands, c.name, "ParameterValue", c.desc)
+		}
+	}
+	for _, p := range n.params {
+		if p.positional() || p.deprecated != "" {
+			continue
+		}
+		if p.long != flags.NoLong {
+			psCandidate(commands, "--"+p.long, "ParameterName", p.desc)
+		}
+		if p.short != flags.NoShort {
+			psCandidate(commands, "-"+string(p.short), "ParameterName", p.desc)
+		}
+	}
+	psCandidate(commands, "--help", "ParameterName", "show help")
+	commands.WriteString("    )\n")
+
+	for _, c := range n.cmds {
+		sub := path + " " + c.name
+		for _, alias := range c.aliases {
+			fmt.Fprintf(aliases, "    $aliases[%v] = %v\n", psQuote(path+" "+alias), psQuote(sub))
+		}
+		c.psCommands(commands, aliases, sub)
+	}
+}
+
+// psCandidate writes a completion candidate, a tooltip must not be empty.
+func psCandidate(w *strings.Builder, text, typ, tip string) {
+	if tip == "" {
+		tip = text
+	}
+	fmt.Fprintf(w, "        @{ Text = %v; Type = '%v'; Tip = %v }\n", psQuote(text), typ, psQuote(tip))
+}
+
+// psQuote quotes s as a verbatim string of PowerShell, in which quotes,
+// including typographic single quotes, are escaped by doubling them.
+func psQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, c := range s {
+		switch c {
+		case '\'', '‘', '’', '‚', '‛':
+			b.WriteRune(c)
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// psCompletion is the script of PowerShellCompletion. Words before the one
+// under cursor resolve the command path, unquoted, and others like flags are
+// skipped. Tables are ordinal, for commands are case-sensitive.
+// Candidates are prefix matched verbatim, for -like takes wildcards.
+const psCompletion = `Register-ArgumentCompleter -Native -CommandName %[1]v -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+
+    $commands = [System.Collections.Hashtable]::new([System.StringComparer]::Ordinal)
+%[2]v    $aliases = [System.Collections.Hashtable]::new([System.StringComparer]::Ordinal)
+%[3]v
+    $path = %[1]v
+    foreach ($element in $commandAst.CommandElements | Select-Object -Skip 1) {
+        if ($element.Extent.EndOffset -ge $cursorPosition) {
+            break
+        }
+        $word = $element.ToString()
+        if ($element -is [System.Management.Automation.Language.StringConstantExpressionAst]) {
+            $word = $element.Value
+        }
+        $next = $path + ' ' + $word
+        if ($aliases.ContainsKey($next)) {
+            $next = $aliases[$next]
+        }
+        if ($commands.ContainsKey($next)) {
+            $path = $next
+        }
+    }
+
+    foreach ($candidate in $commands[$path]) {
+        if ($candidate.Text.StartsWith($wordToComplete, [System.StringComparison]::Ordinal)) {
+            [System.Management.Automation.CompletionResult]::new($candidate.Text, $candidate.Text, $candidate.Type, $candidate.Tip)
+        }
+    }
+}
+`
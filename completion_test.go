@@ -0,0 +1,52 @@
+package flagrouter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPowerShellCompletion(t *testing.T) {
+	r := New("app", "")
+	r.Handle(func(*struct {
+		Verbose bool `short:"v" long:"verbose" desc:"it's verbose"`
+	}) {
+	})
+	r.GroupAlias("db", "database commands", []string{"d"}, func() {
+		r.Group("migrate", "run migrations", func() {
+			r.Handle(func(*struct {
+				Steps int    `long:"steps"`
+				File  string `desc:"positional"`
+			}) {
+			})
+		})
+		r.Group("seed", "", func() {
+			r.DeprecateCommand("use migrate")
+			r.Handle(func() {})
+		})
+	})
+
+	script := r.PowerShellCompletion("app")
+	for _, want := range []string{
+		"Register-ArgumentCompleter -Native -CommandName 'app' -ScriptBlock {",
+		"$commands = [System.Collections.Hashtable]::new([System.StringComparer]::Ordinal)",
+		"$commands['app'] = @(",
+		"@{ Text = 'db'; Type = 'ParameterValue'; Tip = 'database commands' }",
+		"@{ Text = '-v'; Type = 'ParameterName'; Tip = 'it''s verbose' }",
+		"$commands['app db migrate'] = @(",
+		"@{ Text = '--steps'; Type = 'ParameterName'; Tip = '--steps' }",
+		"$aliases['app d'] = 'app db'",
+		"$commands['app db seed'] = @(",
+		"$word = $element.Value",
+	} {
+		if !strings.Contains(script, want) {
+			t.Fatalf("powershell completion: missing %q:\n%v", want, script)
+		}
+	}
+	if strings.Contains(script, "Text = 'seed'") || strings.Contains(script, "positional") {
+		t.Fatalf("powershell completion: unexpected candidates:\n%v", script)
+	}
+
+	if got := psQuote("it’s 'q'"); got != "'it’’s ''q'''" {
+		t.Fatalf("powershell completion: quote: %v", got)
+	}
+}
@@ -0,0 +1,484 @@
+package flagrouter
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eachain/flags"
+)
+
+// LoadConfig reads a JSON file, uses its values as defaults of flags,
+// args of command line still override them.
+//
+// Top-level keys are long names of flags, and a key named after
+// a subcommand holds an object scoped to that subcommand,
+// whose values override the outer ones:
+//
+//	{
+//		"log-level": "info",
+//		"db": {
+//			"log-level": "debug",
+//			"migrate": {"steps": 3}
+//		}
+//	}
+//
+// Strings and numbers are converted the same way as tag `dft`,
+// arrays and objects can be used for slices and maps.
+//
+// Keys unknown to all commands are warned, or reported as an error
+// in strict mode, see StrictConfig. So config should be loaded
+// after all commands and flags registered.
+func (r *Router) LoadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("flagrouter: load config: %w", err)
+	}
+
+	config, err := decodeJSON(data)
+	if err != nil {
+		return fmt.Errorf("flagrouter: load config %v: %w", path, err)
+	}
+	return r.setConfig(config)
+}
+
+func decodeJSON(data []byte) (map[string]any, error) {
+	var config map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadConfigYAML is the same as LoadConfig, except that it reads YAML from rd,
+// decoded by gopkg.in/yaml.v3. Only the first document is read.
+func (r *Router) LoadConfigYAML(rd io.Reader) error {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return fmt.Errorf("flagrouter: load yaml config: %w", err)
+	}
+	config, err := decodeYAML(data)
+	if err != nil {
+		return fmt.Errorf("flagrouter: load yaml config: %w", err)
+	}
+	return r.setConfig(config)
+}
+
+// StrictConfig sets whether unknown keys in config are errors, default false.
+func (r *Router) StrictConfig(strict bool) {
+	r.strictConfig = strict
+}
+
+// ConfigFlag registers a persistent flag `--long` to all cmds, like
+// `--config app.json`, which loads the config file for the run, instead of
+// config loaded by LoadConfig. The format is inferred by extension of the
+// file: JSON for .json, YAML for .yaml and .yml, and TOML for .toml, decoded
+// by github.com/BurntSushi/toml. Args still override config of the file,
+// which overrides defaults of flags.
+func (r *Router) ConfigFlag(long string) {
+	if r.configFlag != nil {
+		panic(fmt.Errorf("flagrouter: config flag %v: already registered as %v", long, r.configFlag.long))
+	}
+	r.configFlag = &param{
+		field:      "Config",
+		ptr:        new(string),
+		long:       long,
+		desc:       "load config from file",
+		holder:     "file",
+		persistent: true,
+	}
+	r.root.add(r.configFlag)
+	r.record(func(c *Router) { c.ConfigFlag(long) })
+}
+
+// runConfig returns config of the run: the file given by the config flag,
+// if any, or else config loaded by LoadConfig.
+func (r *Router) runConfig(st *state) (map[string]any, error) {
+	if r.configFlag == nil || !st.set[r.configFlag] {
+		return r.config, nil
+	}
+
+	path := *r.configFlag.ptr.(*string)
+	decode := decodeJSON
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".yaml", ".yml":
+		decode = decodeYAML
+	case ".toml":
+		decode = decodeTOML
+	default:
+		return nil, fmt.Errorf("flagrouter: load config %v: unsupported format %q, must be .json, .yaml, .yml or .toml", path, ext)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("flagrouter: load config: %w", err)
+	}
+	config, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("flagrouter: load config %v: %w", path, err)
+	}
+	if err = r.checkConfig(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (r *Router) setConfig(config map[string]any) error {
+	if err := r.checkConfig(config); err != nil {
+		return err
+	}
+	r.config = config
+	return nil
+}
+
+// checkConfig warns unknown keys of config, or returns an error of them
+// in strict mode.
+func (r *Router) checkConfig(config map[string]any) error {
+	if unknown := r.root.unknownConfig(config, ""); len(unknown) > 0 {
+		if r.strictConfig {
+			return fmt.Errorf(r.tr("flagrouter.unknown_config_keys",
+				"flagrouter: unknown config keys: %v"), strings.Join(unknown, ", "))
+		}
+		for _, key := range unknown {
+			fmt.Fprintf(r.stderr(), r.tr("flagrouter.unknown_config_key",
+				"flagrouter: warning: unknown config key: %v")+"\n", key)
+		}
+	}
+	return nil
+}
+
+// unknownConfig returns keys in section unknown to n and its subcommands,
+// prefixed with their section path, like `db.migrate.steps`.
+func (n *node) unknownConfig(section map[string]any, prefix string) []string {
+	var unknown []string
+	for k, v := range section {
+		if c := n.lookupCmd(k); c != nil {
+			if m, ok := v.(map[string]any); ok {
+				unknown = append(unknown, c.unknownConfig(m, prefix+k+".")...)
+				continue
+			}
+		}
+		if !n.knows(k) {
+			unknown = append(unknown, prefix+k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// knows reports whether n or any of its subcommands has flag long.
+func (n *node) knows(long string) bool {
+	for _, p := range n.params {
+		if p.long == long {
+			return true
+		}
+	}
+	for _, c := range n.cmds {
+		if c.knows(long) {
+			return true
+		}
+	}
+	return false
+}
+
+// configOf returns config values of cmd path, keyed by long name.
+// A key named after the next subcommand in path is always a section.
+func configOf(config map[string]any, path []*node) map[string]any {
+	values := make(map[string]any)
+	for i, section := 0, config; section != nil; i++ {
+		var next string
+		if i+1 < len(path) {
+			next = path[i+1].name
+		}
+		for k, v := range section {
+			if k != next {
+				values[k] = v
+			}
+		}
+		if next == "" {
+			break
+		}
+		section, _ = section[next].(map[string]any)
+	}
+	return values
+}
+
+// applyConfig sets config values to params not set by args.
+func (st *state) applyConfig(config map[string]any) error {
+	if len(config) == 0 {
+		return nil
+	}
+
+	values := configOf(config, st.path)
+	for _, p := range st.node.params {
+		if st.set[p] || p.long == flags.NoLong {
+			continue
+		}
+		v, ok := values[p.long]
+		if !ok || v == nil {
+			continue
+		}
+		val := reflect.ValueOf(p.ptr).Elem()
+		x, err := parseConfig(val.Type(), v, p.opts())
+		if err != nil {
+			return fmt.Errorf("flagrouter: config %v: %w", p.long, err)
+		}
+		val.Set(reflect.ValueOf(x).Convert(val.Type()))
+	}
+	return nil
+}
+
+// parseConfig converts v decoded from config file to typ,
+// scalars are parsed by parseValue.
+func parseConfig(typ reflect.Type, v any, opts parseOpts) (any, error) {
+	if opts.json {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return parseValue(typ, string(data), opts)
+	}
+
+	switch v := v.(type) {
+	case string:
+		return parseValue(typ, v, opts)
+
+	case json.Number:
+		return parseValue(typ, v.String(), opts)
+
+	case bool:
+		return parseValue(typ, strconv.FormatBool(v), opts)
+
+	case []any:
+		if typ.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("cannot use array as %v", typ)
+		}
+		elemTyp := typ.Elem()
+		ls := reflect.MakeSlice(typ, 0, len(v))
+		for _, elem := range v {
+			val, err := parseConfig(elemTyp, elem, opts)
+			if err != nil {
+				return nil, err
+			}
+			ls = reflect.Append(ls, reflect.ValueOf(val).Convert(elemTyp))
+		}
+		return ls.Interface(), nil
+
+	case map[string]any:
+		if isKVStruct(typ) {
+			return parseConfigStruct(typ, v)
+		}
+		if typ.Kind() != reflect.Map {
+			return nil, fmt.Errorf("cannot use object as %v", typ)
+		}
+		kt := typ.Key()
+		vt := typ.Elem()
+		m := reflect.MakeMapWithSize(typ, len(v))
+		for k, elem := range v {
+			key, err := parseValue(kt, k, opts)
+			if err != nil {
+				return nil, err
+			}
+			val, err := parseConfig(vt, elem, opts)
+			if err != nil {
+				return nil, err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(kt), reflect.ValueOf(val).Convert(vt))
+		}
+		return m.Interface(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported config value %v(%T)", v, v)
+}
+
+// DumpSecrets sets whether values of flags tagged `secret:"true"` are dumped
+// by DumpConfig and ConfigJSON, they are redacted or excluded by default.
+func (r *Router) DumpSecrets(enable bool) {
+	r.dumpSecrets = enable
+}
+
+// DumpConfig writes the effective values of flags of the last run command,
+// or defaults of the root command if not run yet, one `name: value` per line.
+// Values of flags tagged `secret:"true"` are redacted, see DumpSecrets.
+func (r *Router) DumpConfig(w io.Writer) {
+	params, values := r.values()
+	for i, p := range params {
+		value := formatValue(values[i].Interface())
+		if p.secret && !r.dumpSecrets {
+			value = "******"
+		}
+		fmt.Fprintf(w, "%v: %v\n", strings.TrimLeft(p.name(), "-"), value)
+	}
+}
+
+// values returns flags and their values of the last run command,
+// or flags and their defaults of the root command if not run yet.
+func (r *Router) values() ([]*param, []reflect.Value) {
+	var params []*param
+	var values []reflect.Value
+	if last := r.lastRun(); last != nil {
+		for _, p := range last.node.params {
+			if !p.positional() {
+				params = append(params, p)
+				values = append(values, last.value(p.ptr))
+			}
+		}
+		return params, values
+	}
+
+	for _, p := range r.root.params {
+		if p.positional() {
+			continue
+		}
+		params = append(params, p)
+		if p.dft != nil {
+			values = append(values, reflect.ValueOf(p.dft))
+		} else {
+			values = append(values, reflect.Zero(reflect.TypeOf(p.ptr).Elem()))
+		}
+	}
+	return params, values
+}
+
+// ConfigJSON returns the effective values of flags of the last run command,
+// or defaults of the root command if not run yet, as a JSON object keyed by
+// long name, which can be loaded by LoadConfig again. Flags without long name
+// are excluded, and so are flags tagged `secret:"true"`, see DumpSecrets.
+func (r *Router) ConfigJSON() ([]byte, error) {
+	config := make(map[string]any)
+	params, values := r.values()
+	for i, p := range params {
+		if p.long == flags.NoLong || (p.secret && !r.dumpSecrets) {
+			continue
+		}
+		config[p.long] = configValue(values[i], p.char)
+	}
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// configValue converts val to a JSON value which parseConfig accepts.
+func configValue(val reflect.Value, char bool) any {
+	if isFlagValue(val.Type()) {
+		ptr := reflect.New(val.Type())
+		ptr.Elem().Set(val)
+		return ptr.Interface().(flag.Value).String()
+	}
+
+	switch val.Type() {
+	case typDuration:
+		return time.Duration(val.Int()).String()
+	case typDateTime:
+		return val.Interface().(time.Time).Format(flags.DateTime)
+	case typMAC:
+		return val.Interface().(net.HardwareAddr).String()
+	case typEmail:
+		if val.IsNil() {
+			return nil
+		}
+		return val.Interface().(*mail.Address).String()
+	case typEmailVal:
+		addr := val.Interface().(mail.Address)
+		return addr.String()
+	}
+
+	if name, ok := enumName(val); ok {
+		return name
+	}
+	if v, valid, ok := nullValue(val); ok {
+		if !valid {
+			return nil
+		}
+		return configValue(v, char)
+	}
+
+	// underlying values, for named types may implement json.Marshaler
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if char && val.Kind() == reflect.Int32 {
+			return string(rune(val.Int()))
+		}
+		return val.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return val.Uint()
+	case reflect.Float32, reflect.Float64:
+		return val.Float()
+	case reflect.Bool:
+		return val.Bool()
+	case reflect.String:
+		return val.String()
+	case reflect.Complex64, reflect.Complex128:
+		return strconv.FormatComplex(val.Complex(), 'g', -1, val.Type().Bits())
+
+	case reflect.Slice:
+		ls := make([]any, val.Len())
+		for i := range ls {
+			ls[i] = configValue(val.Index(i), char)
+		}
+		return ls
+
+	case reflect.Map:
+		m := make(map[string]any, val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(configValue(iter.Key(), char))
+			m[key] = configValue(iter.Value(), char)
+		}
+		return m
+
+	case reflect.Struct:
+		if !isKVStruct(val.Type()) {
+			break
+		}
+		_, params, err := newStruct(val.Type())
+		if err != nil {
+			break
+		}
+		m := make(map[string]any, len(params))
+		for i, p := range params {
+			if p != nil {
+				m[p.long] = configValue(val.Field(i), p.char)
+			}
+		}
+		return m
+	}
+	return val.Interface()
+}
+
+// parseConfigStruct parses object v to a struct of typ, keys of v are
+// the same as keys of values like `host=a,port=1`, see parseStruct.
+func parseConfigStruct(typ reflect.Type, v map[string]any) (any, error) {
+	val, params, err := newStruct(typ)
+	if err != nil {
+		return nil, err
+	}
+	for k, x := range v {
+		i := slices.IndexFunc(params, func(p *param) bool { return p != nil && p.long == k })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown field %q of %v", k, typ)
+		}
+		p, field := params[i], val.Field(i)
+		if s, ok := x.(string); ok && field.Kind() == reflect.String {
+			x = p.transform(s)
+		}
+		y, err := parseConfig(field.Type(), x, p.opts())
+		if err != nil {
+			return nil, fmt.Errorf("field %q of %v: %w", k, typ, err)
+		}
+		field.Set(reflect.ValueOf(y).Convert(field.Type()))
+	}
+	return checkStruct(val, params)
+}
@@ -0,0 +1,352 @@
+package flagrouter
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type configOptions struct {
+	Level string         `long:"log-level" dft:"info"`
+	Ports []int          `long:"ports"`
+	Tags  map[string]int `long:"tags"`
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	var opt *configOptions
+	r := New("config", "")
+	r.Use(func(o *configOptions) { opt = o })
+	r.Handle(func() {})
+	r.HandleGroup("db", "", func() {})
+
+	err := r.LoadConfig(writeConfig(t, `{
+		"log-level": "warn",
+		"ports": [80, 443],
+		"tags": {"a": 1, "b": "2"},
+		"db": {"log-level": "debug"}
+	}`))
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+
+	_, err = r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("config run: %v", err)
+	}
+	if opt.Level != "warn" ||
+		!reflect.DeepEqual(opt.Ports, []int{80, 443}) ||
+		!reflect.DeepEqual(opt.Tags, map[string]int{"a": 1, "b": 2}) {
+		t.Fatalf("config: options: %+v", *opt)
+	}
+
+	_, err = r.Run(context.Background(), "db")
+	if err != nil {
+		t.Fatalf("config run db: %v", err)
+	}
+	if opt.Level != "debug" {
+		t.Fatalf("config: db log level: %v", opt.Level)
+	}
+
+	_, err = r.Run(context.Background(), "--log-level", "error", "db")
+	if err != nil {
+		t.Fatalf("config run db: %v", err)
+	}
+	if opt.Level != "error" {
+		t.Fatalf("config: args log level: %v", opt.Level)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	r := New("config", "")
+	r.Handle(func(opt *configOptions) {
+		t.Fatalf("config: handler should not run")
+	})
+
+	err := r.LoadConfig(writeConfig(t, `{"ports": ["x"]}`))
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	_, err = r.Run(context.Background())
+	if err == nil {
+		t.Fatalf("config: invalid value run without error")
+	}
+
+	if err = r.LoadConfig(writeConfig(t, `{`)); err == nil {
+		t.Fatalf("config: load invalid json without error")
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	var opt *configOptions
+	r := New("config", "")
+	r.Use(func(o *configOptions) { opt = o })
+	r.Handle(func() {})
+	r.HandleGroup("db", "", func() {})
+
+	err := r.LoadConfigYAML(strings.NewReader(`
+# defaults of all commands
+log-level: warn
+ports:
+  - 80
+  - 443
+tags: {a: 1, b: "2"}
+db:
+  log-level: debug
+`))
+	if err != nil {
+		t.Fatalf("load yaml config: %v", err)
+	}
+
+	_, err = r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("yaml config run: %v", err)
+	}
+	if opt.Level != "warn" ||
+		!reflect.DeepEqual(opt.Ports, []int{80, 443}) ||
+		!reflect.DeepEqual(opt.Tags, map[string]int{"a": 1, "b": 2}) {
+		t.Fatalf("yaml config: options: %+v", *opt)
+	}
+
+	_, err = r.Run(context.Background(), "db")
+	if err != nil {
+		t.Fatalf("yaml config run db: %v", err)
+	}
+	if opt.Level != "debug" {
+		t.Fatalf("yaml config: db log level: %v", opt.Level)
+	}
+}
+
+func TestStrictConfig(t *testing.T) {
+	r := New("config", "")
+	r.Handle(func(opt *configOptions) {})
+	r.HandleGroup("db", "", func() {})
+
+	buf := new(bytes.Buffer)
+	r.SetOutput(buf)
+	const config = "log-level: warn\nunknown: 1\ndb:\n  missing: 2\n"
+	if err := r.LoadConfigYAML(strings.NewReader(config)); err != nil {
+		t.Fatalf("load yaml config: %v", err)
+	}
+	if buf.String() != "flagrouter: warning: unknown config key: db.missing\n"+
+		"flagrouter: warning: unknown config key: unknown\n" {
+		t.Fatalf("config: warnings: %q", buf.String())
+	}
+
+	r.StrictConfig(true)
+	err := r.LoadConfigYAML(strings.NewReader(config))
+	if err == nil || !strings.Contains(err.Error(), "db.missing, unknown") {
+		t.Fatalf("strict config: %v", err)
+	}
+}
+
+type logLevel int
+
+func (l logLevel) MarshalText() ([]byte, error) {
+	return []byte([]string{"debug", "info", "warn"}[l]), nil
+}
+
+func TestDumpConfig(t *testing.T) {
+	type dumped struct {
+		Level    logLevel      `long:"level" dft:"1"`
+		Name     string        `short:"n" long:"name" dft:"app"`
+		Tags     []string      `long:"tag"`
+		Timeout  time.Duration `long:"timeout" dft:"1s"`
+		Password string        `long:"password" secret:"true"`
+		Quiet    bool          `short:"q"`
+	}
+
+	r := New("dump", "")
+	r.Handle(func(*dumped) {})
+
+	var buf strings.Builder
+	r.DumpConfig(&buf)
+	want := "level: \"info\"\nname: \"app\"\ntag: []\ntimeout: 1s\npassword: ******\nq: false\n"
+	if buf.String() != want {
+		t.Fatalf("dump config: defaults:\n%v\nwant:\n%v", buf.String(), want)
+	}
+
+	_, err := r.Run(context.Background(), "--level", "2", "--tag", "a", "--tag", "b", "--password", "123", "-q")
+	if err != nil {
+		t.Fatalf("dump config: run: %v", err)
+	}
+	buf.Reset()
+	r.DumpConfig(&buf)
+	want = "level: \"warn\"\nname: \"app\"\ntag: [a b]\ntimeout: 1s\npassword: ******\nq: true\n"
+	if buf.String() != want {
+		t.Fatalf("dump config:\n%v\nwant:\n%v", buf.String(), want)
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, `--level flagrouter.logLevel (default: "info")`) {
+		t.Fatalf("dump config: usage:\n%v", usage)
+	}
+}
+
+func TestConfigJSON(t *testing.T) {
+	type snapshot struct {
+		Level    logLevel          `long:"level" dft:"1"`
+		Name     string            `short:"n" long:"name" dft:"app"`
+		Ports    []int             `long:"port"`
+		Labels   map[string]string `long:"label"`
+		LM       []map[string]int  `long:"list-map"`
+		Timeout  time.Duration     `long:"timeout" dft:"1s"`
+		Start    time.Time         `long:"start"`
+		C        complex64         `long:"complex"`
+		Delim    rune              `long:"delim" char:"true" dft:","`
+		Password string            `long:"password" secret:"true"`
+		Quiet    bool              `short:"q"`
+	}
+
+	var got snapshot
+	r := New("snapshot", "")
+	r.Handle(func(opt snapshot) { got = opt })
+
+	_, err := r.Run(context.Background(), "--level", "2", "-n", "svc", "--port", "80", "--port", "443",
+		"--label", "a:1,b:2", "--list-map", "x:1", "--list-map", "y:2,z:3", "--timeout", "1m30s",
+		"--start", "2024-01-02T15:04:05", "--complex", "1-2i", "--delim", ";", "--password", "123", "-q")
+	if err != nil {
+		t.Fatalf("config json: run: %v", err)
+	}
+	first := got
+
+	data, err := r.ConfigJSON()
+	if err != nil {
+		t.Fatalf("config json: %v", err)
+	}
+	if strings.Contains(string(data), "password") || strings.Contains(string(data), `"q"`) {
+		t.Fatalf("config json: secret or short flag dumped:\n%s", data)
+	}
+
+	path := writeConfig(t, string(data))
+	r = New("snapshot", "")
+	r.Handle(func(opt snapshot) { got = opt })
+	if err = r.LoadConfig(path); err != nil {
+		t.Fatalf("config json: load: %v", err)
+	}
+	if _, err = r.Run(context.Background(), "--password", "123", "-q"); err != nil {
+		t.Fatalf("config json: run with config: %v", err)
+	}
+	if !reflect.DeepEqual(got, first) {
+		t.Fatalf("config json: round trip: %+v, want %+v", got, first)
+	}
+
+	r.DumpSecrets(true)
+	if data, _ = r.ConfigJSON(); !strings.Contains(string(data), `"password": "123"`) {
+		t.Fatalf("config json: secret not dumped:\n%s", data)
+	}
+}
+
+func TestConfigStruct(t *testing.T) {
+	var got []server
+	newRouter := func() *Router {
+		r := New("config_struct", "")
+		r.Handle(func(opt *struct {
+			Servers []server `long:"server"`
+		}) {
+			got = opt.Servers
+		})
+		return r
+	}
+
+	r := newRouter()
+	path := writeConfig(t, `{"server": [{"host": "A", "port": 1}, {"host": "b", "secure": true}]}`)
+	if err := r.LoadConfig(path); err != nil {
+		t.Fatalf("config struct: load: %v", err)
+	}
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("config struct: run: %v", err)
+	}
+	want := []server{{Host: "a", Port: 1}, {Host: "b", Port: 80, Secure: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("config struct: %+v", got)
+	}
+
+	data, err := r.ConfigJSON()
+	if err != nil {
+		t.Fatalf("config struct: config json: %v", err)
+	}
+	r = newRouter()
+	if err = r.LoadConfig(writeConfig(t, string(data))); err != nil {
+		t.Fatalf("config struct: load dumped config: %v", err)
+	}
+	got = nil
+	if _, err = r.Run(context.Background()); err != nil {
+		t.Fatalf("config struct: run with dumped config: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("config struct: round trip: %+v\n%s", got, data)
+	}
+
+	r = newRouter()
+	if err = r.LoadConfig(writeConfig(t, `{"server": [{"name": "a"}]}`)); err != nil {
+		t.Fatalf("config struct: load: %v", err)
+	}
+	if _, err = r.Run(context.Background()); err == nil {
+		t.Fatalf("config struct: expect error for unknown field")
+	}
+}
+
+func TestConfigFlag(t *testing.T) {
+	var opt *configOptions
+	r := New("config", "")
+	r.SetOutput(new(bytes.Buffer))
+	r.ConfigFlag("config")
+	r.Use(func(o *configOptions) { opt = o })
+	r.Handle(func() {})
+	r.HandleGroup("db", "", func() {})
+
+	jsonPath := writeConfig(t, `{"log-level": "warn", "ports": [80], "db": {"log-level": "debug"}}`)
+	yamlPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(yamlPath, []byte("log-level: error\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	tomlPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(tomlPath, []byte("log-level = \"warn\"\nports = [80, 443]\n\n[db]\nlog-level = \"debug\"\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	for _, c := range []struct {
+		args  []string
+		level string
+		ports []int
+	}{
+		{nil, "info", nil},
+		{[]string{"--config", jsonPath}, "warn", []int{80}},
+		{[]string{"--config", jsonPath, "--log-level", "trace"}, "trace", []int{80}},
+		{[]string{"--log-level=trace", "--config=" + jsonPath}, "trace", []int{80}},
+		{[]string{"db", "--config", jsonPath}, "debug", []int{80}},
+		{[]string{"--config", yamlPath}, "error", nil},
+		{[]string{"--config", tomlPath}, "warn", []int{80, 443}},
+		{[]string{"db", "--config", tomlPath}, "debug", []int{80, 443}},
+		{nil, "info", nil},
+	} {
+		if _, err := r.Run(context.Background(), c.args...); err != nil {
+			t.Fatalf("config flag: run %q: %v", c.args, err)
+		}
+		if opt.Level != c.level || !reflect.DeepEqual(opt.Ports, c.ports) {
+			t.Fatalf("config flag: run %q: %+v", c.args, *opt)
+		}
+	}
+
+	_, err := r.Run(context.Background(), "--config", filepath.Join(t.TempDir(), "config.ini"))
+	if err == nil || !strings.Contains(err.Error(), "unsupported format") {
+		t.Fatalf("config flag: ini: %v", err)
+	}
+	_, err = r.Run(context.Background(), "--config", filepath.Join(t.TempDir(), "missing.json"))
+	if err == nil || !strings.Contains(err.Error(), "load config") {
+		t.Fatalf("config flag: missing file: %v", err)
+	}
+}
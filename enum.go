@@ -0,0 +1,65 @@
+package flagrouter
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	enumsMu sync.RWMutex
+	enums   = make(map[reflect.Type]map[string]int64)
+)
+
+// RegisterEnum registers names of values of an enum type T, so that
+// defaults, args and config of T and its slices and maps are parsed by names,
+// like `dft:"info"`. Registering T again replaces its names.
+// Enums should be registered before flags of them, like in init.
+func RegisterEnum[T ~int](values map[string]T) {
+	names := make(map[string]int64, len(values))
+	for name, v := range values {
+		names[name] = int64(v)
+	}
+	enumsMu.Lock()
+	enums[reflect.TypeOf(T(0))] = names
+	enumsMu.Unlock()
+}
+
+func enumNames(typ reflect.Type) (map[string]int64, bool) {
+	enumsMu.RLock()
+	defer enumsMu.RUnlock()
+	names, ok := enums[typ]
+	return names, ok
+}
+
+// parseEnum parses name s to a value of enum typ.
+func parseEnum(typ reflect.Type, names map[string]int64, s string) (any, error) {
+	v, ok := names[s]
+	if !ok {
+		valid := make([]string, 0, len(names))
+		for name := range names {
+			valid = append(valid, name)
+		}
+		sort.Strings(valid)
+		return nil, fmt.Errorf("invalid %v %q, valid names: %v", typ, s, strings.Join(valid, ", "))
+	}
+	return reflect.ValueOf(v).Convert(typ).Interface(), nil
+}
+
+// enumName returns name of value val of an enum type,
+// the least one if val has more than one names.
+func enumName(val reflect.Value) (string, bool) {
+	names, ok := enumNames(val.Type())
+	if !ok {
+		return "", false
+	}
+	var found string
+	for name, v := range names {
+		if v == val.Int() && (found == "" || name < found) {
+			found = name
+		}
+	}
+	return found, found != ""
+}
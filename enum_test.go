@@ -0,0 +1,74 @@
+package flagrouter
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type level int
+
+const (
+	levelDebug level = iota
+	levelInfo
+	levelWarn
+)
+
+func (l level) String() string {
+	return [...]string{"debug", "info", "warn"}[l]
+}
+
+func init() {
+	RegisterEnum(map[string]level{"debug": levelDebug, "info": levelInfo, "warn": levelWarn, "warning": levelWarn})
+}
+
+func TestEnum(t *testing.T) {
+	type enumOptions struct {
+		Level  level            `long:"level" dft:"info"`
+		Levels []level          `long:"levels" dft:"debug,warn"`
+		Mods   map[string]level `long:"mod"`
+	}
+	var got enumOptions
+	r := New("enum", "")
+	r.Handle(func(opt enumOptions) { got = opt })
+
+	ctx := context.Background()
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("enum: run: %v", err)
+	}
+	want := enumOptions{Level: levelInfo, Levels: []level{levelDebug, levelWarn}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("enum: default: %+v", got)
+	}
+
+	if _, err := r.Run(ctx, "--level", "warning", "--levels", "info", "--mod", "db:debug"); err != nil {
+		t.Fatalf("enum: run: %v", err)
+	}
+	want = enumOptions{Level: levelWarn, Levels: []level{levelInfo}, Mods: map[string]level{"db": levelDebug}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("enum: flags: %+v", got)
+	}
+
+	data, err := r.ConfigJSON()
+	if err != nil || !strings.Contains(string(data), `"level": "warn"`) {
+		t.Fatalf("enum: config json: %s, %v", data, err)
+	}
+
+	_, err = r.Run(ctx, "--level", "fatal")
+	if err == nil || !strings.Contains(err.Error(), `invalid flagrouter.level "fatal", valid names: debug, info, warn, warning`) {
+		t.Fatalf("enum: invalid name: %v", err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("enum: expect registration error for invalid default")
+			}
+		}()
+		New("enum", "").Handle(func(struct {
+			L level `long:"l" dft:"1"`
+		}) {
+		})
+	}()
+}
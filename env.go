@@ -0,0 +1,74 @@
+package flagrouter
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/eachain/flags"
+)
+
+// SetEnvPrefix makes flags not set by args read values from environment
+// variables named by prefix and their long names, like `APP_LOG_LEVEL` for
+// `--log-level` with prefix `APP`: long names are upper cased, and chars other
+// than letters and digits are replaced by `_`, like `APP_DB_HOST` for
+// `--db.host`. A trailing `_` of prefix is optional. Fields tagged like
+// `env:"NAME"` read variable NAME instead, and fields tagged `env:"-"` read
+// nothing. An empty prefix disables it. Environment variables override config
+// and defaults, and args override them.
+func (r *Router) SetEnvPrefix(prefix string) {
+	r.envPrefix = strings.TrimSuffix(prefix, "_")
+}
+
+// EnvPrefix is the same as SetEnvPrefix.
+func (r *Router) EnvPrefix(prefix string) {
+	r.SetEnvPrefix(prefix)
+}
+
+// envName returns name of the environment variable of p, or "" if none.
+func (p *param) envName(prefix string) string {
+	if p.env == "-" {
+		return ""
+	}
+	if p.env != "" {
+		return p.env
+	}
+	if prefix == "" || p.long == flags.NoLong {
+		return ""
+	}
+	return prefix + "_" + strings.Map(func(c rune) rune {
+		switch {
+		case 'a' <= c && c <= 'z':
+			return c - 'a' + 'A'
+		case 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			return c
+		}
+		return '_'
+	}, p.long)
+}
+
+// applyEnv sets values of environment variables to params not set by args.
+// Empty variables are ignored.
+func (st *state) applyEnv(prefix string) error {
+	for _, p := range st.node.params {
+		if st.set[p] {
+			continue
+		}
+		name := p.envName(prefix)
+		if name == "" {
+			continue
+		}
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		val := reflect.ValueOf(p.ptr).Elem()
+		x, err := parseValue(val.Type(), s, p.opts())
+		if err != nil {
+			return fmt.Errorf("flagrouter: env %v: %w", name, err)
+		}
+		val.Set(reflect.ValueOf(x).Convert(val.Type()))
+	}
+	return nil
+}
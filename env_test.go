@@ -0,0 +1,83 @@
+package flagrouter
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	type envOptions struct {
+		Level string   `long:"log-level" dft:"info"`
+		Token string   `long:"token" env:"SECRET_TOKEN"`
+		Hosts []string `long:"host"`
+		Debug bool     `long:"debug" env:"-"`
+	}
+	var got envOptions
+	r := New("env", "")
+	r.SetEnvPrefix("APP")
+	r.Handle(func(opt envOptions) { got = opt })
+
+	ctx := context.Background()
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("env: run: %v", err)
+	}
+	if want := (envOptions{Level: "info"}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("env: no env: %+v", got)
+	}
+
+	t.Setenv("APP_LOG_LEVEL", "debug")
+	t.Setenv("APP_TOKEN", "ignored")
+	t.Setenv("SECRET_TOKEN", "123")
+	t.Setenv("APP_HOST", "a,b")
+	t.Setenv("APP_DEBUG", "true")
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("env: run: %v", err)
+	}
+	want := envOptions{Level: "debug", Token: "123", Hosts: []string{"a", "b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("env: %+v", got)
+	}
+
+	if _, err := r.Run(ctx, "--log-level", "warn", "--host", "c"); err != nil {
+		t.Fatalf("env: run: %v", err)
+	}
+	want = envOptions{Level: "warn", Token: "123", Hosts: []string{"c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("env: args over env: %+v", got)
+	}
+
+	r.EnvPrefix("")
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("env: run: %v", err)
+	}
+	if want := (envOptions{Level: "info", Token: "123"}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("env: prefix disabled: %+v", got)
+	}
+
+	r = New("env", "")
+	r.Handle(func(*struct {
+		N int `long:"n" env:"APP_N"`
+	}) {
+	})
+	t.Setenv("APP_N", "x")
+	if _, err := r.Run(ctx); err == nil || !strings.Contains(err.Error(), "env APP_N") {
+		t.Fatalf("env: invalid: %v", err)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	r := New("env", "")
+	r.SetEnvPrefix("APP_")
+	for long, want := range map[string]string{
+		"max-conns": "APP_MAX_CONNS",
+		"db.host":   "APP_DB_HOST",
+		"TLS_cert2": "APP_TLS_CERT2",
+	} {
+		p := &param{long: long}
+		if name := p.envName(r.envPrefix); name != want {
+			t.Fatalf("env name: %v: %v, want %v", long, name, want)
+		}
+	}
+}
@@ -2,23 +2,275 @@ package flagrouter
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"os"
+	"os/signal"
 	"reflect"
+	"regexp"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/eachain/flags"
 )
 
 type Router struct {
-	fs *flags.FlagSet
+	mu   sync.Mutex // serializes parsing of runs, see Run
+	root *node
+	cur  *node
+
+	lastMu  sync.Mutex // guards last and lastCmd, not held by runs
+	last    *state     // the last run built its options, see Value
+	lastCmd []string   // see LastCommand
+
+	config       map[string]any
+	strictConfig bool
+	dumpSecrets  bool
+
+	outw io.Writer // help
+	errw io.Writer // warnings and errors
+	in   io.Reader // stdin of flags tagged fromstdin
+
+	responseFiles bool
+	stdinArgs     bool
+	interspersed  bool
+	prefixMatch   bool
+	passthrough   bool
+	autoShort     bool
+	stopOnCancel  bool
+	recoverPanics bool
+	envPrefix     string
+	signals       []os.Signal                  // see CancelOnSignals
+	translator    func(key, msg string) string // see SetTranslator
+	helpAll       *param                       // see EnableHelpAll
+	configFlag    *param                       // see ConfigFlag
+
+	validators map[string]func(any) error // see RegisterValidator
+	provided   map[reflect.Type]any       // see Provide
+	ops        []op                       // registrations, see Clone
 }
 
 func New(name, desc string) *Router {
-	return &Router{
-		fs: flags.New(name, desc),
+	root := &node{fs: flags.New(name, desc), name: name, desc: desc}
+	r := &Router{
+		root:         root,
+		cur:          root,
+		interspersed: true,
+	}
+	root.fs.Use(r.prepare)
+	return r
+}
+
+// prepare is the outermost middleware of all handlers.
+// It runs after flags.FlagSet parsed args, and applies what
+// flags.FlagSet does not know about to params, such as config.
+func (r *Router) prepare(ctx context.Context, handler flags.Handler) {
+	st := getState(ctx)
+	r.warnDeprecated(st)
+	if st.unknown == nil && st.node.handler == nil {
+		// only HandleUnknown registered
+		st.err = fmt.Errorf("flags: %w of command %v", ErrNoExecFunc, st.cmdPath())
+		return
+	}
+	st.applyDefaults()
+	if err := st.applyValues(); err != nil {
+		st.err = err
+		return
+	}
+	st.mergeDefaults()
+	if err := st.applyPositionals(); err != nil {
+		st.err = err
+		return
+	}
+	config, err := r.runConfig(st)
+	if err != nil {
+		st.err = err
+		return
+	}
+	if err := st.applyConfig(config); err != nil {
+		st.err = err
+		return
+	}
+	if err := st.applyEnv(r.envPrefix); err != nil {
+		st.err = err
+		return
+	}
+	if err := r.applyRequired(st); err != nil {
+		st.err = err
+		return
+	}
+	st.applyTransforms()
+	st.applySets()
+	if err := st.validate(); err != nil {
+		st.err = err
+		return
+	}
+	if st.dryRun {
+		return
+	}
+	// middlewares and handlers get options from the snapshot of st,
+	// so other runs can parse args from now on
+	st.release()
+	r.checkCanceled(handler)(ctx)
+}
+
+// StopOnCancel sets whether middlewares and handlers are skipped once ctx is
+// done, default false. If enabled, ctx is checked before every next handler
+// called by middlewares, and Run returns the error of ctx if it is done.
+// Middlewares and handlers running are not stopped.
+func (r *Router) StopOnCancel(enable bool) {
+	r.stopOnCancel = enable
+}
+
+// checkCanceled wraps next so that it is skipped if ctx is done, see StopOnCancel.
+func (r *Router) checkCanceled(next flags.Handler) flags.Handler {
+	return func(ctx context.Context) {
+		if r.stopOnCancel && ctx.Err() != nil {
+			if st := getState(ctx); st.err == nil {
+				st.err = ctx.Err()
+			}
+			return
+		}
+		next(ctx)
+	}
+}
+
+// node is a registration scope, opened by New, Group or Stmt.
+type node struct {
+	fs         *flags.FlagSet
+	name       string
+	aliases    []string
+	desc       string
+	longDesc   string   // see SetLongDesc
+	deprecated string   // see DeprecateCommand
+	defaultSub *node    // see DefaultSub
+	owner      *node    // the cmd which a stmt belongs to, nil if node is a cmd
+	cmds       []*node  // subcommands, including those registered in stmts
+	subs       []*node  // nested groups and stmts
+	params     []*param // flags registered to fs, including inherited ones
+	pre        []flags.Handler
+	post       []flags.Handler
+
+	handler flags.Handler // registered by Handle
+	unknown flags.Handler // registered by HandleUnknown
+}
+
+// sub returns a nested scope inherits params and hooks of n,
+// just like flags.FlagSet inherits params of its parent.
+func (n *node) sub(fs *flags.FlagSet) *node {
+	s := &node{
+		fs:     fs,
+		desc:   n.desc,
+		params: n.params[:len(n.params):len(n.params)],
+		pre:    n.pre[:len(n.pre):len(n.pre)],
+		post:   n.post[:len(n.post):len(n.post)],
+	}
+	n.subs = append(n.subs, s)
+	return s
+}
+
+// cmd returns a nested scope of subcommand name, which can also be called by aliases.
+// It panics if name or aliases conflict with names or aliases of its siblings.
+func (n *node) cmd(name, desc string, aliases ...string) *node {
+	owner := n
+	if n.owner != nil {
+		owner = n.owner
+	}
+	for i, s := range aliases {
+		if s == "" || s == name || slices.Contains(aliases[:i], s) {
+			panic(fmt.Errorf("flagrouter: command %v: invalid alias %q", name, s))
+		}
+	}
+	for _, s := range append([]string{name}, aliases...) {
+		if c := owner.lookupCmd(s); c != nil {
+			panic(fmt.Errorf("flagrouter: command %v: name %v conflicts with command %v", name, s, c.name))
+		}
+	}
+
+	c := n.sub(n.fs.Cmd(name, desc))
+	c.name = name
+	c.desc = desc
+	c.aliases = aliases
+	owner.cmds = append(owner.cmds, c)
+	return c
+}
+
+// stmt returns a nested statement scope.
+func (n *node) stmt() *node {
+	s := n.sub(n.fs.Stmt())
+	s.owner = n
+	if n.owner != nil {
+		s.owner = n.owner
+	}
+	return s
+}
+
+// add registers p to n. If p is persistent,
+// it is also registered to all nested scopes those already exist.
+// Positional params are not registered to flags.FlagSet, see applyPositionals.
+func (n *node) add(p *param) {
+	if !p.positional() {
+		n.fs.AnyVar(p.ptr, p.short, p.long, p.dft, p.desc, p.sep...)
+	}
+	n.params = append(n.params, p)
+	if p.persistent {
+		for _, s := range n.subs {
+			s.inherit(p)
+		}
+	}
+}
+
+// inherit registers persistent p to n, unless n already has a flag
+// with the same short or long name: the nearest definition always wins,
+// so p is not propagated to n and its nested scopes.
+func (n *node) inherit(p *param) {
+	for _, q := range n.params {
+		if (p.short != flags.NoShort && q.short == p.short) ||
+			(p.long != flags.NoLong && q.long == p.long) {
+			return
+		}
+	}
+	n.add(p)
+}
+
+// dispatch is the handler of n registered to flags.FlagSet,
+// it calls the unknown handler if Run resolved an unknown subcommand.
+func (n *node) dispatch(ctx context.Context) {
+	st := getState(ctx)
+	st.dispatched = true
+	if st.unknown != nil {
+		n.unknown(ctx)
+	} else {
+		n.handler(ctx)
+	}
+}
+
+// hook wraps h with PreRun and PostRun hooks of n.
+func (n *node) hook(h flags.Handler) flags.Handler {
+	if len(n.pre) == 0 && len(n.post) == 0 {
+		return h
+	}
+	pre, post := n.pre, n.post
+	return func(ctx context.Context) {
+		for _, fn := range pre {
+			fn(ctx)
+		}
+		h(ctx)
+		for i := len(post) - 1; i >= 0; i-- {
+			post[i](ctx)
+		}
 	}
 }
 
@@ -44,8 +296,11 @@ func (r *Router) Use(middlewares ...any) {
 		if err != nil {
 			panic(err)
 		}
-		r.fs.Use(m)
+		r.cur.fs.Use(func(ctx context.Context, next flags.Handler) {
+			m(ctx, r.checkCanceled(next))
+		})
 	}
+	r.record(func(c *Router) { c.Use(middlewares...) })
 }
 
 // handler must be one of following format:
@@ -54,34 +309,259 @@ func (r *Router) Use(middlewares ...any) {
 //   - `func(arg)` or `func(*arg)`
 //   - `func(context.Context, arg)` or `func(context.Context, *arg)`
 //
+// and it can also receive values registered by Provide.
+// handler can also return an error, which will be returned by Run,
+// or a string and an error, the string is returned by Run, see SetResult,
 // and arg must be like:
 //
 //	struct {
 //		A int `short:"a" long:"all" dft:"123" desc:"what is a"`
 //	}
 func (r *Router) Handle(handler any) {
+	r.HandleWith(handler)
+}
+
+// HandleWith is the same as Handle, except that handler is wrapped by
+// middlewares, which apply to handler only, in the same order as Use:
+// the first one is the outermost. They run inside middlewares of current
+// group/stmt, and outside PreRun and PostRun hooks.
+// middlewares must be of the formats that Use accepts.
+func (r *Router) HandleWith(handler any, middlewares ...any) {
 	h, err := r.parseFunc(handler)
 	if err != nil {
 		panic(err)
 	}
-	r.fs.Handle(h)
+	h = r.cur.hook(h)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		m, err := r.parseMiddleware(middlewares[i])
+		if err != nil {
+			panic(err)
+		}
+		next := r.checkCanceled(h)
+		h = func(ctx context.Context) { m(ctx, next) }
+	}
+	r.cur.handler = h
+	r.cur.fs.Handle(r.cur.dispatch)
+	r.record(func(c *Router) { c.HandleWith(handler, middlewares...) })
+}
+
+// HandleUnknown register a handler runs when the first non-flag arg
+// of current group is not a registered subcommand, which is useful to
+// dispatch to external plugins. The handler receives the arg as cmd,
+// and all args after it, and runs with middlewares of current group.
+// Unknown subcommands take precedence over positional arguments.
+func (r *Router) HandleUnknown(handler func(ctx context.Context, cmd string, args []string) error) {
+	r.cur.unknown = r.cur.hook(func(ctx context.Context) {
+		st := getState(ctx)
+		st.fail(handler(ctx, st.unknown[0], st.unknown[1:]))
+	})
+	if r.cur.handler == nil {
+		r.cur.fs.Handle(r.cur.dispatch)
+	}
+	r.record(func(c *Router) { c.HandleUnknown(handler) })
+}
+
+// Fallback register a handler runs when the first non-flag arg of current
+// group is not a registered subcommand, like an interactive shell does with
+// unrecognized input. The handler receives the arg and all args after it,
+// and handler must be one of following format:
+//   - `func([]string)` or `func([]string) error`
+//   - `func(context.Context, []string)` or `func(context.Context, []string) error`
+//
+// It is the same as HandleUnknown otherwise, and replaces it.
+func (r *Router) Fallback(handler any) {
+	var fn func(ctx context.Context, args []string) error
+	switch h := handler.(type) {
+	case func([]string):
+		fn = func(_ context.Context, args []string) error { h(args); return nil }
+	case func([]string) error:
+		fn = func(_ context.Context, args []string) error { return h(args) }
+	case func(context.Context, []string):
+		fn = func(ctx context.Context, args []string) error { h(ctx, args); return nil }
+	case func(context.Context, []string) error:
+		fn = h
+	default:
+		panic(fmt.Errorf("flagrouter: unsupported fallback handler: %T", handler))
+	}
+
+	r.cur.unknown = r.cur.hook(func(ctx context.Context) {
+		st := getState(ctx)
+		st.fail(fn(ctx, st.unknown))
+	})
+	if r.cur.handler == nil {
+		r.cur.fs.Handle(r.cur.dispatch)
+	}
+	r.record(func(c *Router) { c.Fallback(handler) })
+}
+
+// PreRun register a hook runs immediately before every handler registered after it
+// in current group/stmt, no matter how middlewares call their next handler.
+// fn must be one of the formats that Handle accepts.
+func (r *Router) PreRun(fn any) {
+	h, err := r.parseFunc(fn)
+	if err != nil {
+		panic(err)
+	}
+	r.cur.pre = append(r.cur.pre, h)
+	r.record(func(c *Router) { c.PreRun(fn) })
+}
+
+// PostRun register a hook runs immediately after every handler registered after it
+// in current group/stmt. PostRun hooks run in reverse order of registration.
+// fn must be one of the formats that Handle accepts,
+// or `func(error)` or `func(context.Context, error)` to receive the handler's error.
+func (r *Router) PostRun(fn any) {
+	r.cur.post = append(r.cur.post, r.parsePostFunc(fn))
+	r.record(func(c *Router) { c.PostRun(fn) })
+}
+
+// Defer registers fn runs after every handler registered after it in current
+// group/stmt, and after middlewares registered after it returned, no matter
+// where they call their next handler, even if the handler failed or panicked.
+// fn must be one of the formats that PostRun accepts.
+func (r *Router) Defer(fn any) {
+	h := r.parsePostFunc(fn)
+	r.cur.fs.Use(func(ctx context.Context, next flags.Handler) {
+		defer h(ctx)
+		next(ctx)
+	})
+	r.record(func(c *Router) { c.Defer(fn) })
+}
+
+// parsePostFunc parses fn of PostRun and Defer.
+func (r *Router) parsePostFunc(fn any) flags.Handler {
+	switch f := fn.(type) {
+	case func(error):
+		return func(ctx context.Context) { f(getState(ctx).err) }
+	case func(context.Context, error):
+		return func(ctx context.Context) { f(ctx, getState(ctx).err) }
+	}
+	h, err := r.parseFunc(fn)
+	if err != nil {
+		panic(err)
+	}
+	return h
 }
 
 // Group open a new cmd group, use closure to register subcommands.
 func (r *Router) Group(name, desc string, closure func()) {
-	fs := r.fs
-	r.fs = fs.Cmd(name, desc)
+	n := r.cur
+	r.cur = r.openCmd(name, desc, nil)
 	closure()
-	r.fs = fs
+	r.cur = n
+}
+
+// GroupAlias is like Group, but the cmd can also be called by aliases,
+// which are listed with name in help. Conflicting names or aliases of
+// cmds in the same group cause a panic.
+func (r *Router) GroupAlias(name, desc string, aliases []string, closure func()) {
+	n := r.cur
+	r.cur = r.openCmd(name, desc, aliases)
+	closure()
+	r.cur = n
+}
+
+// Name returns the name of current cmd, given to New or Group.
+func (r *Router) Name() string {
+	n := r.cur
+	if n.owner != nil {
+		n = n.owner
+	}
+	return n.name
+}
+
+// Desc returns the description of current cmd, given to New or Group.
+func (r *Router) Desc() string {
+	n := r.cur
+	if n.owner != nil {
+		n = n.owner
+	}
+	return n.desc
+}
+
+// FlagSet returns the flags.FlagSet of current group/stmt, as an escape hatch
+// to capabilities of flags not wrapped by Router yet. It is only supported
+// for read-only introspection, registering to it directly is not: Router
+// knows nothing about what is registered, and Clone does not copy it.
+func (r *Router) FlagSet() *flags.FlagSet {
+	return r.cur.fs
+}
+
+// SetLongDesc sets the long description of current cmd, which is shown in
+// its own help, while the desc given to New or Group is shown in listings.
+func (r *Router) SetLongDesc(desc string) {
+	n := r.cur
+	if n.owner != nil {
+		n = n.owner
+	}
+	n.longDesc = desc
+	r.record(func(c *Router) { c.SetLongDesc(desc) })
+}
+
+// DeprecateCommand marks current cmd deprecated, a warning with msg
+// is printed to output when it or its subcommands run.
+func (r *Router) DeprecateCommand(msg string) {
+	n := r.cur
+	if n.owner != nil {
+		n = n.owner
+	}
+	n.deprecated = msg
+	r.record(func(c *Router) { c.DeprecateCommand(msg) })
+}
+
+// DefaultSub makes subcommand name of current cmd run if no subcommand follows
+// current cmd, like `app remote` for `app remote show`. Subcommand name must
+// have been registered. Explicit subcommands take precedence, and help of
+// current cmd is still shown by `-h`, `--help` and `help`. Flags of current cmd
+// following it are parsed before running the default subcommand, and other args,
+// such as flags of the default subcommand, are parsed by the default subcommand.
+func (r *Router) DefaultSub(name string) {
+	n := r.cur
+	if n.owner != nil {
+		n = n.owner
+	}
+	c := n.lookupCmd(name)
+	if c == nil {
+		panic(fmt.Errorf("flagrouter: command %v: default subcommand %v not found", n.name, name))
+	}
+	n.defaultSub = c
+	r.record(func(c *Router) { c.DefaultSub(name) })
+}
+
+// warnDeprecated prints warnings of deprecated cmds and flags used by st.
+func (r *Router) warnDeprecated(st *state) {
+	for i, n := range st.path {
+		if n.deprecated != "" {
+			name := strings.Join(st.cmdNames()[:i+1], " ")
+			fmt.Fprintf(r.stderr(), r.tr("flagrouter.deprecated_command",
+				"flagrouter: warning: command %v is deprecated: %v")+"\n", name, n.deprecated)
+		}
+	}
+	for _, p := range st.node.params {
+		if p.deprecated != "" && st.set[p] {
+			fmt.Fprintf(r.stderr(), r.tr("flagrouter.deprecated_option",
+				"flagrouter: warning: option %v is deprecated: %v")+"\n", p.name(), p.deprecated)
+		}
+	}
 }
 
 // Stmt open a new empty statement, use closure to register subcommands.
 // It is always used to register some middlewares those not influence other cmds.
 func (r *Router) Stmt(closure func()) {
-	fs := r.fs
-	r.fs = fs.Stmt()
+	n := r.cur
+	r.cur = r.openStmt()
 	closure()
-	r.fs = fs
+	r.cur = n
+}
+
+// WithMiddlewares is the same as Stmt, with middlewares used first,
+// so that they apply to cmds registered in closure only.
+// middlewares must be of the formats that Use accepts.
+func (r *Router) WithMiddlewares(middlewares []any, closure func()) {
+	r.Stmt(func() {
+		r.Use(middlewares...)
+		closure()
+	})
 }
 
 // handler must be one of following format:
@@ -90,6 +570,7 @@ func (r *Router) Stmt(closure func()) {
 //   - `func(arg)` or `func(*arg)`
 //   - `func(context.Context, arg)` or `func(context.Context, *arg)`
 //
+// handler can also return an error, which will be returned by Run,
 // and arg must be like:
 //
 //	struct {
@@ -107,9 +588,392 @@ var (
 	ErrHelp         = flags.ErrHelp
 )
 
-// Run parse args and exec the subcommand.
+// CmdError is the error returned by a handler, with the command path.
+type CmdError struct {
+	path []string
+	err  error
+}
+
+func (e *CmdError) Error() string {
+	return strings.Join(e.path, " ") + ": " + e.err.Error()
+}
+
+// Path returns names of cmds from root to the one returned the error.
+func (e *CmdError) Path() []string {
+	return e.path
+}
+
+func (e *CmdError) Unwrap() error {
+	return e.err
+}
+
+// PanicError is the error returned by Run if a middleware or handler panicked,
+// see RecoverPanics.
+type PanicError struct {
+	value any
+	stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("flagrouter: panic: %v", e.value)
+}
+
+// Value returns the value passed to panic.
+func (e *PanicError) Value() any {
+	return e.value
+}
+
+// Stack returns the stack trace of the goroutine where the panic was recovered.
+func (e *PanicError) Stack() []byte {
+	return e.stack
+}
+
+// Unwrap returns the value passed to panic if it is an error, or nil.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.value.(error)
+	return err
+}
+
+// Run parse args and exec the subcommand. It returns help of the command,
+// or the result of the handler if set, see SetResult.
+//
+// Run is safe for concurrent use once all cmds registered. Runs parse args
+// one at a time, for values of flags are bound to options shared by runs,
+// but middlewares and handlers run concurrently, each on a copy of options
+// of its own run, and they can call Run of the same Router. Value, DumpConfig
+// and ConfigJSON report the last run of any goroutine, and variables of Var
+// are written by every run, read them by Value in concurrent use.
 func (r *Router) Run(ctx context.Context, args ...string) (string, error) {
-	return r.fs.Run(ctx, args...)
+	_, usage, err := r.run(ctx, args, false)
+	return usage, err
+}
+
+// RunWithTimeout is the same as Run, except that ctx passed to middlewares
+// and handlers is canceled after timeout. Handlers are not stopped forcibly,
+// they should observe cancellation of ctx. If the deadline exceeded before
+// the handler returned without an error, context.DeadlineExceeded is returned.
+func (r *Router) RunWithTimeout(ctx context.Context, timeout time.Duration, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	usage, err := r.Run(ctx, args...)
+	if err == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = ctx.Err()
+	}
+	return usage, err
+}
+
+// Resolve parses args like Run, and returns names of the resolved cmd path,
+// like [app db migrate], without running middlewares and handlers.
+// Defaults, config, environment variables and validations still apply,
+// and values of flags can be got by Value after it.
+func (r *Router) Resolve(ctx context.Context, args ...string) ([]string, error) {
+	st, _, err := r.run(ctx, args, true)
+	if st == nil {
+		return nil, err
+	}
+	return st.cmdNames(), err
+}
+
+// run runs args, st is nil if args are not scanned.
+// If dryRun, it stops right before middlewares.
+func (r *Router) run(ctx context.Context, args []string, dryRun bool) (st *state, usage string, err error) {
+	r.mu.Lock()
+	unlocked := false
+	defer func() {
+		if !unlocked {
+			r.mu.Unlock()
+		}
+	}()
+	defer func() {
+		var names []string
+		if st != nil && st.dispatched {
+			names = st.cmdNames()
+		}
+		r.lastMu.Lock()
+		r.lastCmd = names
+		r.lastMu.Unlock()
+	}()
+
+	stdin := r.stdin()
+	if r.stdinArgs {
+		if args, stdin, err = expandStdinArgs(args, stdin); err != nil {
+			return nil, r.usage(), err
+		}
+	}
+	if r.responseFiles {
+		if args, err = expandResponseFiles(args, 0); err != nil {
+			return nil, r.usage(), err
+		}
+	}
+
+	st = r.scan(args)
+	st.stdin = stdin
+	st.stdout = r.stdout()
+	st.translator = r.translator
+	st.dryRun = dryRun
+	st.reset()
+	st.release = func() {
+		if unlocked {
+			return
+		}
+		unlocked = true
+		st.snapshot()
+		r.lastMu.Lock()
+		r.last = st
+		r.lastMu.Unlock()
+		r.mu.Unlock()
+	}
+	defer st.release()
+	if st.err != nil {
+		return st, st.usage(), st.err
+	}
+	if r.helpAll != nil && st.set[r.helpAll] {
+		return st, st.usageAll(), ErrHelp
+	}
+	if r.recoverPanics {
+		defer func() {
+			if v := recover(); v != nil {
+				usage, err = st.usage(), &PanicError{value: v, stack: debug.Stack()}
+			}
+		}()
+	}
+	_, err = r.root.fs.Run(putState(ctx, st), st.args...)
+	if err == nil {
+		err = st.err
+	}
+	if st.result != nil {
+		return st, *st.result, err
+	}
+	return st, st.usage(), err
+}
+
+// SetResult sets s as the string returned by Run, instead of help of the
+// command, which is useful for piping output of commands. It can be called
+// by middlewares and handlers, and handlers returning a string and an error
+// call it on return. The last call wins, so a middleware calling it after
+// the handler returned overrides the result of the handler. It does nothing
+// if ctx is not passed by Run.
+func SetResult(ctx context.Context, s string) {
+	if st := getState(ctx); st != nil {
+		st.result = &s
+	}
+}
+
+// RunCmdline runs with args of command line. On help, it prints usage to output,
+// and on error, it prints the error to output and exits with the code of RunMain.
+func (r *Router) RunCmdline(ctx context.Context) {
+	if code, _ := r.RunCmdlineE(ctx); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// RunCmdlineE is the same as RunCmdline, except that it returns the exit code
+// and the error returned by Run instead of exiting, so that callers can run
+// deferred functions before calling os.Exit. The exit code is 0 on help.
+func (r *Router) RunCmdlineE(ctx context.Context) (int, error) {
+	return r.runCmdline(ctx)
+}
+
+// Execute runs args of command line with context.Background(), like cobra.
+// It prints help on help and returns nil, and prints other errors to error
+// output and returns them, leaving exiting to the caller.
+func (r *Router) Execute() error {
+	code, err := r.runCmdline(context.Background())
+	if code == 0 {
+		return nil
+	}
+	return err
+}
+
+// CancelOnSignals sets signals which cancel ctx passed to middlewares and
+// handlers by RunCmdline, RunCmdlineE and Execute, such as os.Interrupt,
+// so that long-running handlers can stop on Ctrl-C by checking ctx.Done().
+// Signals are handled only during the run, and no signal by default.
+func (r *Router) CancelOnSignals(sigs ...os.Signal) {
+	r.signals = sigs
+}
+
+// runCmdline runs args of command line, see runAndReport and CancelOnSignals.
+func (r *Router) runCmdline(ctx context.Context) (int, error) {
+	if len(r.signals) > 0 {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, r.signals...)
+		defer stop()
+	}
+	return r.runAndReport(ctx, os.Args[1:])
+}
+
+// RunMain runs args, prints the result set by SetResult or help to output,
+// or error to error output, and returns the exit code: 0 on success or help,
+// 1 on errors of handlers, including recovered panics and errors of ctx,
+// and 2 on other errors, such as invalid args, or a command without handler,
+// whose help follows the error.
+func (r *Router) RunMain(ctx context.Context, args ...string) int {
+	code, _ := r.runAndReport(ctx, args)
+	return code
+}
+
+// runAndReport runs args, prints the result, help or error to output,
+// and returns the exit code and the error returned by Run.
+func (r *Router) runAndReport(ctx context.Context, args []string) (int, error) {
+	st, usage, err := r.run(ctx, args, false)
+	if st != nil && st.result != nil {
+		if *st.result != "" {
+			fmt.Fprintln(r.stdout(), *st.result)
+		}
+		usage = st.usage()
+	}
+	if err == nil {
+		return 0, nil
+	}
+	if errors.Is(err, ErrHelp) {
+		fmt.Fprintln(r.stdout(), usage)
+		return 0, err
+	}
+	fmt.Fprintln(r.stderr(), err)
+	if errors.Is(err, ErrNoExecFunc) {
+		fmt.Fprintln(r.stderr(), usage)
+	}
+	return exitCode(err), err
+}
+
+// exitCode returns 1 for errors of middlewares and handlers, including
+// recovered panics and errors of ctx, or 2 for others.
+func exitCode(err error) int {
+	var cmdErr *CmdError
+	var panicErr *PanicError
+	if errors.As(err, &cmdErr) || errors.As(err, &panicErr) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 1
+	}
+	return 2
+}
+
+// RecoverPanics sets whether panics of middlewares and handlers are recovered,
+// default false. If enabled, Run returns a *PanicError with the stack trace
+// instead of crashing. Panics of registrations are not affected.
+func (r *Router) RecoverPanics(enable bool) {
+	r.recoverPanics = enable
+}
+
+// AllowPrefixMatch enables or disables calling subcommands by unique prefixes
+// of their names or aliases, like `co` for `checkout`. Exact names always take
+// precedence, and a prefix of more than one subcommand is an error.
+func (r *Router) AllowPrefixMatch(enable bool) {
+	r.prefixMatch = enable
+}
+
+// AutoShort sets whether flags with a long name but no short name get
+// a short name assigned automatically when registered: the first letter of
+// the long name not used by other flags of the cmd, nor by explicit shorts of
+// the same options struct, and never `h`. A flag is long-only if all letters
+// are used. Explicit shorts of flags registered later may still conflict.
+func (r *Router) AutoShort(enable bool) {
+	r.autoShort = enable
+	r.record(func(c *Router) { c.AutoShort(enable) })
+}
+
+// autoShort returns the first letter of long not used as a short by params
+// of n nor in reserved, or flags.NoShort if none, see AutoShort.
+func (n *node) autoShort(long string, reserved []byte) byte {
+	for i := 0; i < len(long); i++ {
+		c := long[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') || c == 'h' || slices.Contains(reserved, c) {
+			continue
+		}
+		if !slices.ContainsFunc(n.params, func(p *param) bool { return p.short == c }) {
+			return c
+		}
+	}
+	return flags.NoShort
+}
+
+// PassthroughUnknown sets whether flags unknown to the command are positional
+// arguments instead of errors, default false, which is useful to wrap another
+// tool. Values following unknown flags are positional arguments too, and order
+// of args is kept. Unknown flags are still errors if the command accepts no
+// positional arguments. `-h` and `--help` are never passed through,
+// and all args after a bare `--` are positional arguments as before.
+func (r *Router) PassthroughUnknown(enable bool) {
+	r.passthrough = enable
+}
+
+// InterspersedFlags sets whether flags after the first positional argument
+// are still parsed as flags, default true, like GNU tools.
+// If disabled, like POSIX tools, all args after the first positional argument
+// are positional arguments, even if they start with `-`.
+func (r *Router) InterspersedFlags(enable bool) {
+	r.interspersed = enable
+}
+
+// LastCommand returns names of cmds from root to the one executed by the
+// last finished run, like [app db migrate], or nil if it executed no cmd,
+// such as on help or invalid args. It is safe to call concurrently with runs,
+// but middlewares and handlers should use Command for the running one.
+func (r *Router) LastCommand() []string {
+	r.lastMu.Lock()
+	defer r.lastMu.Unlock()
+	return r.lastCmd
+}
+
+// Command returns names of cmds from root to the one running with ctx,
+// like LastCommand, for middlewares and handlers. It returns nil if ctx
+// is not passed by Run.
+func Command(ctx context.Context) []string {
+	st := getState(ctx)
+	if st == nil {
+		return nil
+	}
+	return st.cmdNames()
+}
+
+// Reset clears what the last run left, as if r never ran: Value reports
+// no flags, DumpConfig and ConfigJSON show defaults of the root command,
+// and values of all flags are zero until the next run. Registrations are kept.
+// Every run starts clean anyway, Reset is for sharing r across runs, like
+// in a REPL, without leaking values of the last run between them.
+// It waits for runs parsing args, handlers running keep their options.
+func (r *Router) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastMu.Lock()
+	r.last, r.lastCmd = nil, nil
+	r.lastMu.Unlock()
+	r.root.reset()
+}
+
+// reset sets values of params of n and its nested scopes to zero.
+func (n *node) reset() {
+	for _, p := range n.params {
+		reflect.ValueOf(p.ptr).Elem().SetZero()
+	}
+	for _, s := range n.subs {
+		s.reset()
+	}
+}
+
+// Value returns a copy of the value of flag long of the last run command,
+// and whether it was set explicitly by args. The last run is the one which
+// built options for its middlewares and handlers most recently, see Run.
+// It can be called in middlewares and handlers, or after Run returned.
+func (r *Router) Value(long string) (any, bool) {
+	last := r.lastRun()
+	if last == nil || long == flags.NoLong {
+		return nil, false
+	}
+	for _, p := range last.node.params {
+		if p.long == long {
+			return cloneValue(last.value(p.ptr)).Interface(), last.set[p]
+		}
+	}
+	return nil, false
+}
+
+// lastRun returns the last run built its options, or nil if not run yet.
+func (r *Router) lastRun() *state {
+	r.lastMu.Lock()
+	defer r.lastMu.Unlock()
+	return r.last
 }
 
 var (
@@ -119,6 +983,7 @@ var (
 	typHandlerFunc    = reflect.TypeOf(func(ctx context.Context) {})
 	typMiddleware     = reflect.TypeOf(flags.Middleware(func(ctx context.Context, handler flags.Handler) {}))
 	typMiddlewareFunc = reflect.TypeOf(func(ctx context.Context, handler flags.Handler) {})
+	typError          = reflect.TypeOf(new(error)).Elem()
 )
 
 // middleware must be one of following format:
@@ -180,7 +1045,7 @@ func (r *Router) parseMiddleware(mw any) (flags.Middleware, error) {
 			return nil, err
 		}
 		return func(ctx context.Context, handler flags.Handler) {
-			function.Call([]reflect.Value{param})
+			function.Call([]reflect.Value{param(ctx)})
 			handler(ctx)
 		}, nil
 	}
@@ -214,7 +1079,7 @@ func (r *Router) parseMiddleware(mw any) (flags.Middleware, error) {
 			return func(ctx context.Context, handler flags.Handler) {
 				function.Call([]reflect.Value{
 					reflect.ValueOf(ctx),
-					param,
+					param(ctx),
 				})
 				handler(ctx)
 			}, nil
@@ -230,7 +1095,7 @@ func (r *Router) parseMiddleware(mw any) (flags.Middleware, error) {
 		}
 		return func(ctx context.Context, handler flags.Handler) {
 			function.Call([]reflect.Value{
-				param,
+				param(ctx),
 				reflect.ValueOf(func() { handler(ctx) }).Convert(arg1),
 			})
 		}, nil
@@ -252,7 +1117,7 @@ func (r *Router) parseMiddleware(mw any) (flags.Middleware, error) {
 		return func(ctx context.Context, handler flags.Handler) {
 			function.Call([]reflect.Value{
 				reflect.ValueOf(ctx),
-				param,
+				param(ctx),
 				reflect.ValueOf(func() { handler(ctx) }).Convert(arg2),
 			})
 		}, nil
@@ -260,7 +1125,7 @@ func (r *Router) parseMiddleware(mw any) (flags.Middleware, error) {
 	return func(ctx context.Context, handler flags.Handler) {
 		function.Call([]reflect.Value{
 			reflect.ValueOf(ctx),
-			param,
+			param(ctx),
 			reflect.ValueOf(handler).Convert(arg2),
 		})
 	}, nil
@@ -326,7 +1191,8 @@ func (r *Router) parseMiddlewareFast(mw any, typ reflect.Type) (flags.Middleware
 //   - `func(arg)` or `func(*arg)`
 //   - `func(context.Context, arg)` or `func(context.Context, *arg)`
 //
-// and arg must be like:
+// handler can also return an error, which will be returned by Run,
+// or a string and an error, see SetResult, and arg must be like:
 //
 //	struct {
 //		A int `short:"a" long:"all" dft:"123" desc:"what is a"`
@@ -343,43 +1209,57 @@ func (r *Router) parseFunc(fn any) (flags.Handler, error) {
 	if typ == nil || typ.Kind() != reflect.Func {
 		return nil, errors.New("handler must be a func")
 	}
-	if typ.NumOut() != 0 {
-		return nil, errors.New("handler func must return nothing")
+	switch {
+	case typ.NumOut() == 0:
+	case typ.NumOut() == 1 && typ.Out(0) == typError:
+	case typ.NumOut() == 2 && typ.Out(0).Kind() == reflect.String && typ.Out(1) == typError:
+	default:
+		return nil, errors.New("handler func must return nothing, an error, or a string and an error")
 	}
 
-	if typ.NumIn() > 2 {
-		return nil, errors.New("handler func can only receive 0 or 1 or 2 arg in")
-	}
+	// func(context.Context, arg) or func(context.Context, *arg),
+	// and args of provided types, see Provide
+	args := make([]func(ctx context.Context) reflect.Value, typ.NumIn())
+	for i := range args {
+		arg := typ.In(i)
+		if arg == typContext {
+			if i > 0 {
+				return nil, errors.New("handler func can only receive context.Context as the first arg")
+			}
+			args[i] = func(ctx context.Context) reflect.Value { return reflect.ValueOf(ctx) }
+			continue
+		}
 
-	function := reflect.ValueOf(fn)
-	if typ.NumIn() == 0 { // func()
-		return func(context.Context) {
-			function.Call(nil)
-		}, nil
-	}
+		provided, ok, err := r.provider(arg)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			args[i] = func(context.Context) reflect.Value { return reflect.ValueOf(r.provided[provided]) }
+			continue
+		}
 
-	arg0 := typ.In(0)
-	if typ.NumIn() == 1 {
-		// func(arg) or func(*arg)
-		param, err := r.parseFuncArgs(arg0, "handler")
+		param, err := r.parseFuncArgs(arg, "handler")
 		if err != nil {
 			return nil, err
 		}
-		return func(ctx context.Context) {
-			function.Call([]reflect.Value{param})
-		}, nil
+		args[i] = func(ctx context.Context) reflect.Value { return param(ctx) }
 	}
 
-	// func(context.Context, arg) or func(context.Context, *arg)`
-	if arg0 != typContext {
-		return nil, errors.New("handler func with 2 args in, the first arg must be a context.Context")
-	}
-	param, err := r.parseFuncArgs(typ.In(1), "handler")
-	if err != nil {
-		return nil, err
-	}
+	function := reflect.ValueOf(fn)
 	return func(ctx context.Context) {
-		function.Call([]reflect.Value{reflect.ValueOf(ctx), param})
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			in[i] = arg(ctx)
+		}
+		out := function.Call(in)
+		if len(out) == 2 {
+			SetResult(ctx, out[0].String())
+			out = out[1:]
+		}
+		if len(out) > 0 && !out[0].IsNil() {
+			getState(ctx).fail(out[0].Interface().(error))
+		}
 	}, nil
 }
 
@@ -407,161 +1287,747 @@ func (r *Router) parseFuncFast(fn any, typ reflect.Type) (flags.Handler, error)
 	return nil, nil
 }
 
-func (r *Router) parseFuncArgs(arg reflect.Type, who string) (reflect.Value, error) {
+func (r *Router) parseFuncArgs(arg reflect.Type, who string) (func(context.Context) reflect.Value, error) {
 	isPtr := false
 	if arg.Kind() == reflect.Pointer {
 		isPtr = true
 		arg = arg.Elem()
 	}
 	if arg.Kind() != reflect.Struct {
-		return reflect.Value{}, fmt.Errorf("%v func arg must be a struct", who)
+		return nil, fmt.Errorf("%v func arg must be a struct", who)
 	}
 	return r.parseOptions(arg, isPtr)
 }
 
+// parseOptions registers fields of arg as flags, and returns a func returns
+// a fresh copy of the options parsed by the run of ctx on each call, so a
+// handler never sees options of another run, even if it modified them.
 // arg must be like:
 //
 //	struct {
 //		A int `short:"a" long:"all" desc:"what is a" dft:"123"`
 //	}
-func (r *Router) parseOptions(arg reflect.Type, isPtr bool) (reflect.Value, error) {
-	val := reflect.New(arg)
-	ret := val
-	val = val.Elem()
-	if !isPtr {
-		ret = val
+func (r *Router) parseOptions(arg reflect.Type, isPtr bool) (func(context.Context) reflect.Value, error) {
+	order, err := positionOrder(arg)
+	if err != nil {
+		return nil, err
+	}
+	// explicit shorts take precedence over auto ones, see AutoShort
+	var shorts []byte
+	for i := 0; i < arg.NumField(); i++ {
+		if short := arg.Field(i).Tag.Get("short"); len(short) == 1 {
+			shorts = append(shorts, short[0])
+		}
 	}
 
-	for i := 0; i < val.NumField(); i++ {
-		err := r.parseField(arg.Field(i), val.Field(i))
+	val := reflect.New(arg).Elem()
+	var fields []int // indices of fields registered as flags
+	for _, i := range order {
+		err := r.parseField(arg.Field(i), val.Field(i), shorts)
 		if err != nil {
-			return ret, err
+			return nil, fmt.Errorf("flagrouter: field %v of %v: %w", arg.Field(i).Name, arg, err)
+		}
+		if skip, _ := skipField(arg.Field(i)); !skip {
+			fields = append(fields, i)
 		}
 	}
 
-	return ret, nil
+	return func(ctx context.Context) reflect.Value {
+		st := getState(ctx)
+		opts := reflect.New(arg)
+		for _, i := range fields {
+			opts.Elem().Field(i).Set(cloneValue(st.value(val.Field(i).Addr().Interface())))
+		}
+		if isPtr {
+			return opts
+		}
+		return opts.Elem()
+	}, nil
 }
 
-func (r *Router) parseField(field reflect.StructField, val reflect.Value) error {
-	short, long, dft, desc, sep, err := parseTag(field)
+// positionOrder returns indices of fields of arg in order of registration:
+// positional arguments tagged `pos:"N"` are ordered by N, which must be
+// 1 to the number of positional arguments if any of them is tagged,
+// and other fields are in order of declaration.
+func positionOrder(arg reflect.Type) ([]int, error) {
+	order := make([]int, arg.NumField())
+	var slots []int       // indices of order holding positional arguments
+	var byPos map[int]int // pos to field index
+	for i := range order {
+		order[i] = i
+		field := arg.Field(i)
+		if skip, _ := skipField(field); skip || field.Tag.Get("short") != "" || field.Tag.Get("long") != "" {
+			if field.Tag.Get("pos") != "" {
+				return nil, fmt.Errorf("flagrouter: field %v of %v: pos tag: not a positional argument", field.Name, arg)
+			}
+			continue
+		}
+		slots = append(slots, i)
+
+		tag := field.Tag.Get("pos")
+		if tag == "" {
+			continue
+		}
+		pos, err := strconv.Atoi(tag)
+		if err != nil || pos < 1 {
+			return nil, fmt.Errorf("flagrouter: field %v of %v: invalid pos tag %q: must be a positive integer", field.Name, arg, tag)
+		}
+		if j, ok := byPos[pos]; ok {
+			return nil, fmt.Errorf("flagrouter: field %v of %v: pos tag: %v is taken by field %v", field.Name, arg, pos, arg.Field(j).Name)
+		}
+		if byPos == nil {
+			byPos = make(map[int]int)
+		}
+		byPos[pos] = i
+	}
+	if len(byPos) == 0 {
+		return order, nil
+	}
+
+	for pos := 1; pos <= len(slots); pos++ {
+		i, ok := byPos[pos]
+		if !ok {
+			return nil, fmt.Errorf("flagrouter: %v: pos tag: positions must be 1 to %v, missing %v", arg, len(slots), pos)
+		}
+		order[slots[pos-1]] = i
+	}
+	return order, nil
+}
+
+// parseField registers field as a flag bound to val,
+// reserved are shorts which cannot be assigned by AutoShort.
+func (r *Router) parseField(field reflect.StructField, val reflect.Value, reserved []byte) error {
+	if skip, err := skipField(field); err != nil || skip {
+		return err
+	}
+	p, err := parseTag(field)
 	if err != nil {
 		return err
 	}
-	if dft != nil {
-		dft = reflect.ValueOf(dft).Convert(field.Type).Interface()
+	if r.autoShort && p.short == flags.NoShort && p.long != flags.NoLong {
+		p.short = r.cur.autoShort(p.long, reserved)
 	}
+	if p.dft != nil {
+		p.dft = reflect.ValueOf(p.dft).Convert(field.Type).Interface()
+	}
+	if p.checks, err = r.parseValidateTag(field, p.checks); err != nil {
+		return err
+	}
+	p.ptr = val.Addr().Interface()
 
-	r.fs.AnyVar(val.Addr().Interface(), short, long, dft, desc, sep...)
+	r.cur.add(p)
 	return nil
 }
 
-func parseTag(field reflect.StructField) (short byte, long string, dft any, desc string, sep []string, err error) {
+// Option is a tag of a flag registered by Var, see Tag.
+type Option struct {
+	key, value string
+}
+
+// Tag returns an Option works as the struct tag `key:"value"` of options,
+// like Tag("env", "APP_TOKEN").
+func Tag(key, value string) Option {
+	return Option{key: key, value: value}
+}
+
+// Default returns an Option works as tag dft.
+func Default(dft string) Option {
+	return Tag("dft", dft)
+}
+
+// Required returns an Option works as tag `required:"true"`.
+func Required() Option {
+	return Tag("required", "true")
+}
+
+// Var registers a flag of current group/stmt bound to pointer, without
+// an options struct, which is useful to register flags dynamically, such as
+// looping over keys of config. short can be flags.NoShort or 0, and long can
+// be empty; a flag without both is a positional argument. Other tags are
+// given by opts. Runs set values to pointer directly, so values of the last
+// run are kept in it, and the value can also be read by Value, which is safe
+// with concurrent runs, see Run. Clone shares pointer with r.
+func (r *Router) Var(pointer any, short byte, long, desc string, opts ...Option) {
+	val := reflect.ValueOf(pointer)
+	if val.Kind() != reflect.Pointer || val.IsNil() {
+		panic(fmt.Errorf("flagrouter: var %v: pointer must be a non-nil pointer, got %T", long, pointer))
+	}
+
+	tags := []string{fmt.Sprintf("desc:%q", desc)}
+	if short != flags.NoShort {
+		tags = append(tags, fmt.Sprintf("short:%q", string(short)))
+	}
+	if long != flags.NoLong {
+		tags = append(tags, fmt.Sprintf("long:%q", long))
+	}
+	for _, opt := range opts {
+		tags = append(tags, fmt.Sprintf("%v:%q", opt.key, opt.value))
+	}
+	field := reflect.StructField{
+		Name: "Var",
+		Type: val.Type().Elem(),
+		Tag:  reflect.StructTag(strings.Join(tags, " ")),
+	}
+	if err := r.parseField(field, val.Elem(), nil); err != nil {
+		panic(fmt.Errorf("flagrouter: var %v: %w", long, err))
+	}
+	r.record(func(c *Router) { c.Var(pointer, short, long, desc, opts...) })
+}
+
+// param is a flag registered by a struct field.
+type param struct {
+	field      string
+	ptr        any
+	short      byte
+	long       string
+	dft        any
+	desc       string
+	longDesc   string
+	deprecated string
+	sep        []string
+	persistent bool
+	secret     bool
+	transforms []func(string) string
+	checks     []check
+	holder     string // placeholder of value in usage
+	section    string // heading of the flag in usage
+	custom     bool   // parsed by router instead of flags.FlagSet, see applyValues
+	boolFlag   bool   // flag.Value needs no value, see isBoolFlag
+	char       bool
+	unit       string // unit of numbers of durations, see units
+	fromFile   bool
+	fromStdin  bool
+	required   bool
+	json       bool
+	env        string // name of environment variable, see SetEnvPrefix
+	dedup      bool
+	sorted     bool
+	appendDft  bool   // args are appended to the default, see tag merge
+	encoding   string // encoding of []byte, see decodeBytes
+	zeroDft    bool   // tag dft is given explicitly empty, `dft:""`
+}
+
+func parseTag(field reflect.StructField) (*param, error) {
+	p := &param{field: field.Name}
 	if tagShort := field.Tag.Get("short"); tagShort != "" {
 		if len(tagShort) > 1 {
-			err = fmt.Errorf("flagrouter: invalid short tag %q: length must be 1", tagShort)
-			return
+			return nil, fmt.Errorf("invalid short tag %q: length must be 1", tagShort)
 		}
-		short = tagShort[0]
+		p.short = tagShort[0]
 	}
 
-	long = field.Tag.Get("long")
+	p.long = field.Tag.Get("long")
+	p.custom = !flagsParses(field.Type)
+	p.boolFlag = isBoolFlag(field.Type)
+
+	var err error
+	if p.char, err = parseBoolTag(field, "char"); err != nil {
+		return nil, err
+	}
+	if p.char {
+		if typ := elemType(field.Type); typ.Kind() != reflect.Int32 {
+			return nil, fmt.Errorf("char tag: unsupported type: %v", field.Type)
+		}
+		p.custom = true
+	}
+	if p.unit = field.Tag.Get("unit"); p.unit != "" {
+		if _, ok := units[p.unit]; !ok {
+			return nil, fmt.Errorf("invalid unit tag %q: must be one of ns, us, ms, s, m and h", p.unit)
+		}
+		if elemType(field.Type) != typDuration {
+			return nil, fmt.Errorf("unit tag: unsupported type: %v", field.Type)
+		}
+		p.custom = true
+	}
+	// other values of json tag are names for encoding/json, and
+	// `encoding:"json"` is the same as `json:"true"`, for go vet reports
+	// repeated json tags in a struct
+	if field.Tag.Get("json") == "true" || field.Tag.Get("encoding") == "json" {
+		p.json = true
+		p.custom = true
+	}
+	switch p.encoding = field.Tag.Get("encoding"); p.encoding {
+	case "json":
+		p.encoding = ""
+	case "", "hex", "base64":
+	default:
+		return nil, fmt.Errorf("invalid encoding tag %q: must be json, hex or base64", p.encoding)
+	}
+	// `base64:"true"` is short for `encoding:"base64"`
+	if b64, err := parseBoolTag(field, "base64"); err != nil {
+		return nil, err
+	} else if b64 {
+		if p.encoding != "" && p.encoding != "base64" {
+			return nil, fmt.Errorf("base64 tag conflicts with encoding tag %q", p.encoding)
+		}
+		p.encoding = "base64"
+	}
+	if p.encoding != "" {
+		if !isBytes(field.Type) {
+			return nil, fmt.Errorf("encoding tag %v: unsupported type: %v", p.encoding, field.Type)
+		}
+		p.custom = true
+	}
+	if p.fromFile, err = parseBoolTag(field, "fromfile"); err != nil {
+		return nil, err
+	}
+	if p.fromStdin, err = parseBoolTag(field, "fromstdin"); err != nil {
+		return nil, err
+	}
+	if p.fromFile || p.fromStdin {
+		if field.Type.Kind() == reflect.Bool {
+			return nil, fmt.Errorf("fromfile or fromstdin tag: unsupported type: %v", field.Type)
+		}
+		p.custom = true
+	}
 
 	if seperator := strings.TrimSpace(field.Tag.Get("sep")); seperator != "" {
-		sep = make([]string, len(seperator))
+		p.sep = make([]string, len(seperator))
 		for i := 0; i < len(seperator); i++ {
-			sep[i] = string(seperator[i])
+			p.sep[i] = string(seperator[i])
+		}
+	}
+	// seps is like sep, but separators are delimited by spaces,
+	// so that they can be multi-character, like `seps:"|| =>"`.
+	if seps := strings.Fields(field.Tag.Get("seps")); len(seps) > 0 {
+		if p.sep != nil {
+			return nil, errors.New("sep and seps tags are exclusive")
+		}
+		p.sep = seps
+	}
+	if p.sep != nil && !p.json && !isFlagValue(field.Type) {
+		if err := checkSeps(field.Type, len(p.sep)); err != nil {
+			return nil, err
 		}
 	}
+	// []byte is the raw string unless separated explicitly
+	if p.encoding == "" && p.sep == nil && !p.json && isBytes(field.Type) && field.Type != typMAC {
+		p.encoding = "raw"
+		p.custom = true
+	}
+	// flags.FlagSet ignores separators
+	if k := field.Type.Kind(); p.sep != nil && (k == reflect.Slice || k == reflect.Map) {
+		p.custom = true
+	}
 
-	if tagDft := field.Tag.Get("dft"); tagDft != "" {
-		dft, err = parseDefault(field.Type, tagDft, sep...)
+	if !p.json && !routerParses(field.Type) {
+		return nil, fmt.Errorf("unsupported type: %v", field.Type)
+	}
+	tagDft, hasDft := field.Tag.Lookup("dft")
+	if tagDft != "" {
+		dft, err := parseValue(field.Type, tagDft, p.opts())
 		if err != nil {
-			return
+			return nil, fmt.Errorf("dft tag %q: %w", tagDft, err)
 		}
+		p.dft = dft
 	}
+	p.zeroDft = hasDft && tagDft == ""
+
+	switch merge := field.Tag.Get("merge"); merge {
+	case "", "replace":
+	case "append":
+		if k := field.Type.Kind(); k != reflect.Slice && k != reflect.Map {
+			return nil, fmt.Errorf("merge tag: unsupported type: %v", field.Type)
+		}
+		p.appendDft = true
+	default:
+		return nil, fmt.Errorf("invalid merge tag %q: must be append or replace", merge)
+	}
+
+	p.env = field.Tag.Get("env")
+	p.desc = field.Tag.Get("desc")
+	p.longDesc = field.Tag.Get("long_desc")
+	p.deprecated = field.Tag.Get("deprecated")
+	p.holder = field.Tag.Get("placeholder")
+	p.section = field.Tag.Get("section")
+	if p.holder == "" && p.positional() {
+		p.holder = strings.ToUpper(field.Name)
+	}
+
+	if p.persistent, err = parseBoolTag(field, "persistent"); err != nil {
+		return nil, err
+	}
+	if p.persistent && p.positional() {
+		return nil, errors.New("positional field cannot be persistent")
+	}
+	if p.secret, err = parseBoolTag(field, "secret"); err != nil {
+		return nil, err
+	}
+	if p.required, err = parseBoolTag(field, "required"); err != nil {
+		return nil, err
+	}
+	if p.transforms, err = parseTransformTag(field); err != nil {
+		return nil, err
+	}
+	if p.dedup, p.sorted, err = parseSetTags(field); err != nil {
+		return nil, err
+	}
+	if (p.dedup || p.sorted) && p.dft != nil {
+		p.dft = p.normalize(reflect.ValueOf(p.dft)).Interface()
+	}
+	if p.checks, err = parseCheckTags(field, p.sep); err != nil {
+		return nil, err
+	}
+	if p.dft != nil {
+		if err = p.check(reflect.ValueOf(p.dft).Convert(field.Type)); err != nil {
+			return nil, fmt.Errorf("default: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *param) opts() parseOpts {
+	return parseOpts{sep: p.sep, char: p.char, json: p.json, unit: units[p.unit], encoding: p.encoding}
+}
+
+// name returns name of p used in messages.
+func (p *param) name() string {
+	if p.long != flags.NoLong {
+		return "--" + p.long
+	}
+	if p.short != flags.NoShort {
+		return "-" + string(p.short)
+	}
+	return p.holder
+}
+
+// positional reports whether p is a positional argument,
+// which has neither short nor long name.
+func (p *param) positional() bool {
+	return p.short == flags.NoShort && p.long == flags.NoLong
+}
 
-	desc = field.Tag.Get("desc")
+// skipField reports whether field is not a flag: unexported fields, fields
+// tagged `ignore:"true"` whatever other tags they have, and subcommands of
+// Commands.
+func skipField(field reflect.StructField) (bool, error) {
+	if !field.IsExported() || isCommand(field) {
+		return true, nil
+	}
+	return parseBoolTag(field, "ignore")
+}
 
-	return
+func parseBoolTag(field reflect.StructField, name string) (bool, error) {
+	tag := field.Tag.Get(name)
+	if tag == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(tag)
+	if err != nil {
+		return false, fmt.Errorf("invalid %v tag %q: %w", name, tag, err)
+	}
+	return b, nil
 }
 
 var (
 	typDuration = reflect.TypeOf(time.Duration(0))
 	typDateTime = reflect.TypeOf(time.Time{})
+	typRegexp   = reflect.TypeOf((*regexp.Regexp)(nil))
+	typMAC      = reflect.TypeOf(net.HardwareAddr(nil))
+	typEmail    = reflect.TypeOf((*mail.Address)(nil))
+	typEmailVal = reflect.TypeOf(mail.Address{})
 )
 
+// elemType returns the innermost element type of slices and maps.
+func elemType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// units are units of tag `unit`, durations tagged with a unit can be numbers
+// without unit, like `500` for `500ms` with `unit:"ms"`.
+var units = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// parseChar parses s as a single character, escapes like `\t` are decoded.
+func parseChar(s string) (rune, error) {
+	if utf8.RuneCountInString(s) == 1 {
+		r, _ := utf8.DecodeRuneInString(s)
+		return r, nil
+	}
+	if r, _, tail, err := strconv.UnquoteChar(s, '\''); err == nil && tail == "" {
+		return r, nil
+	}
+	return 0, fmt.Errorf("invalid character %q: must be exactly one character", s)
+}
+
+var typFlagValue = reflect.TypeOf(new(flag.Value)).Elem()
+
+// isFlagValue reports whether *typ implements flag.Value of std library.
+func isFlagValue(typ reflect.Type) bool {
+	return reflect.PointerTo(typ).Implements(typFlagValue)
+}
+
+// isBoolFlag reports whether *typ is a flag.Value whose IsBoolFlag returns true,
+// which needs no value in args like bool flags of std library.
+func isBoolFlag(typ reflect.Type) bool {
+	if !isFlagValue(typ) {
+		return false
+	}
+	b, ok := reflect.New(typ).Interface().(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// flagsParses reports whether flags.FlagSet is able to parse values of typ.
+func flagsParses(typ reflect.Type) bool {
+	if isFlagValue(typ) {
+		return false
+	}
+	if _, ok := enumNames(typ); ok {
+		return false
+	}
+	if typ == typDuration || typ == typDateTime {
+		return true
+	}
+	switch typ.Kind() {
+	// integers are parsed by router, which accepts prefixes of bases like
+	// 0x, 0o and 0b, while flags.FlagSet parses them in decimal
+	case reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return true
+	// bools in slices and maps are parsed by router, see parseBool
+	case reflect.Slice:
+		return typ.Elem().Kind() != reflect.Bool && flagsParses(typ.Elem())
+	// flags.FlagSet splits datetimes of map values by their colons
+	case reflect.Map:
+		return typ.Key().Kind() != reflect.Bool && typ.Elem().Kind() != reflect.Bool &&
+			typ.Elem() != typDateTime && flagsParses(typ.Key()) && flagsParses(typ.Elem())
+	}
+	return false
+}
+
+// parseBool is like strconv.ParseBool, but also accepts
+// yes, no, on and off, case-insensitively.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "t", "true", "yes", "on":
+		return true, nil
+	case "0", "f", "false", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid bool %q, valid values: true, false, yes, no, on, off, 1, 0", s)
+}
+
+// checkSeps checks number n of separators given to typ. Slices use 1 separator,
+// maps and structs use 2: element and key value, and maps of slices can use
+// the 3rd one for elements of values. Slices of maps and structs need all 3:
+// element, key value and group.
+func checkSeps(typ reflect.Type, n int) error {
+	var min, max int
+	switch {
+	case typ.Kind() == reflect.Slice && (typ.Elem().Kind() == reflect.Map || isKVStruct(typ.Elem())):
+		min, max = 3, 3
+	case typ.Kind() == reflect.Slice:
+		min, max = 1, 1
+	case typ.Kind() == reflect.Map && typ.Elem().Kind() == reflect.Slice:
+		min, max = 1, 3
+	case typ.Kind() == reflect.Map || isKVStruct(typ):
+		min, max = 1, 2
+	}
+	if n < min {
+		return fmt.Errorf("sep tag: %v needs %v separators: element, key value and group, got %v", typ, min, n)
+	}
+	if n > max {
+		return fmt.Errorf("sep tag: %v uses at most %v separators, got %v", typ, max, n)
+	}
+	return nil
+}
+
+// routerParses reports whether parseValue is able to parse values of typ.
+// Fields of structs are checked when parsed.
+func routerParses(typ reflect.Type) bool {
+	if isFlagValue(typ) || typ == typDuration || typ == typDateTime || typ == typRegexp || typ == typMAC ||
+		typ == typEmail || typ == typEmailVal {
+		return true
+	}
+	if _, ok := enumNames(typ); ok {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+		reflect.Bool, reflect.String, reflect.Struct:
+		return true
+	case reflect.Slice:
+		return routerParses(typ.Elem())
+	case reflect.Map:
+		return routerParses(typ.Key()) && routerParses(typ.Elem())
+	}
+	return false
+}
+
+// parseOpts are options of parsing values from string.
+type parseOpts struct {
+	sep  []string
+	char bool          // parse int32 as a character, see parseChar
+	unit time.Duration // unit of numbers parsed as durations, see units
+	json bool          // parse by json.Unmarshal
+	// encoding of []byte, raw, hex or base64, see decodeBytes
+	encoding string
+}
+
 func parseDefault(typ reflect.Type, dft string, sep ...string) (any, error) {
+	return parseValue(typ, dft, parseOpts{sep: sep})
+}
+
+// parseValue parses s to a value of typ, which is convertible to typ.
+func parseValue(typ reflect.Type, s string, opts parseOpts) (any, error) {
+	if opts.json {
+		ptr := reflect.New(typ)
+		if err := json.Unmarshal([]byte(s), ptr.Interface()); err != nil {
+			return nil, err
+		}
+		return ptr.Elem().Interface(), nil
+	}
+	if isFlagValue(typ) {
+		ptr := reflect.New(typ)
+		if err := ptr.Interface().(flag.Value).Set(s); err != nil {
+			return nil, err
+		}
+		return ptr.Elem().Interface(), nil
+	}
+	if opts.encoding != "" && isBytes(typ) {
+		return decodeBytes(s, opts.encoding)
+	}
+
+	if names, ok := enumNames(typ); ok {
+		return parseEnum(typ, names, s)
+	}
+
 	switch typ {
 	case typDuration:
-		return time.ParseDuration(dft)
+		if f, err := strconv.ParseFloat(s, 64); err == nil && opts.unit != 0 {
+			return time.Duration(f * float64(opts.unit)), nil
+		}
+		return time.ParseDuration(s)
 	case typDateTime:
-		return time.ParseInLocation(flags.DateTime, dft, time.Local)
+		return time.ParseInLocation(flags.DateTime, s, time.Local)
+	case typRegexp:
+		return regexp.Compile(s)
+	case typMAC:
+		return net.ParseMAC(s)
+	case typEmail, typEmailVal:
+		addr, err := mail.ParseAddress(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid email address %q: %w", s, err)
+		}
+		if typ == typEmailVal {
+			return *addr, nil
+		}
+		return addr, nil
+	}
+	// names of addresses may contain commas, like `"Doe, John" <john@example.com>`
+	if typ.Kind() == reflect.Slice && (typ.Elem() == typEmail || typ.Elem() == typEmailVal) {
+		return parseEmails(typ, s)
+	}
+	if i, ok := nullField(typ); ok {
+		return parseNull(typ, i, s, opts)
 	}
 
 	switch typ.Kind() {
 	default:
-		return nil, fmt.Errorf("flagrouter: unsupported type: %v", typ)
+		return nil, fmt.Errorf("unsupported type: %v", typ)
 
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return strconv.ParseInt(dft, 10, 64)
+		if opts.char && typ.Kind() == reflect.Int32 {
+			return parseChar(s)
+		}
+		// base 0 accepts prefixes 0x, 0o and 0b, handy for bitmasks and permissions.
+		// Values are converted to typ later, which wraps silently,
+		// so they are parsed with the bit size of typ.
+		i, err := strconv.ParseInt(s, 0, typ.Bits())
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, fmt.Errorf("value %v overflows %v: %w", s, typ, strconv.ErrRange)
+		}
+		return i, err
 
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return strconv.ParseUint(dft, 10, 64)
+		u, err := strconv.ParseUint(s, 0, typ.Bits())
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, fmt.Errorf("value %v overflows %v: %w", s, typ, strconv.ErrRange)
+		}
+		return u, err
 
 	case reflect.Float32, reflect.Float64:
-		return strconv.ParseFloat(dft, 64)
+		return strconv.ParseFloat(s, 64)
+
+	case reflect.Complex64, reflect.Complex128:
+		return strconv.ParseComplex(s, 128)
 
 	case reflect.Bool:
-		return strconv.ParseBool(dft)
+		return parseBool(s)
 
 	case reflect.String:
-		return dft, nil
+		return s, nil
 
 	case reflect.Slice:
 		elemTyp := typ.Elem()
 		seperator := ","
-		if len(sep) > 0 && sep[0] != "" {
-			seperator = sep[0]
+		if len(opts.sep) > 0 && opts.sep[0] != "" {
+			seperator = opts.sep[0]
 		}
-		if elemTyp.Kind() == reflect.Map {
+		if elemTyp.Kind() == reflect.Map || isKVStruct(elemTyp) {
 			seperator = ";"
-			if len(sep) > 2 && sep[2] != "" {
-				seperator = sep[2]
+			if len(opts.sep) > 2 && opts.sep[2] != "" {
+				seperator = opts.sep[2]
 			}
 		}
-		elems := strings.Split(dft, seperator)
+		elems := strings.Split(s, seperator)
 		ls := reflect.MakeSlice(typ, 0, len(elems))
-		for _, elem := range elems {
-			val, err := parseDefault(elemTyp, strings.TrimSpace(elem), sep...)
+		for i, elem := range elems {
+			elem = strings.TrimSpace(elem)
+			val, err := parseValue(elemTyp, elem, opts)
 			if err != nil {
+				// name the failed one among several elements
+				if len(elems) > 1 {
+					err = fmt.Errorf("element %v %q: %w", i, elem, err)
+				}
 				return nil, err
 			}
 			ls = reflect.Append(ls, reflect.ValueOf(val).Convert(elemTyp))
 		}
 		return ls.Interface(), nil
 
+	case reflect.Struct:
+		return parseStruct(typ, s, opts)
+
 	case reflect.Map:
 		m := reflect.MakeMap(typ)
 		sepElem := ","
-		if len(sep) > 0 && sep[0] != "" {
-			sepElem = sep[0]
+		if len(opts.sep) > 0 && opts.sep[0] != "" {
+			sepElem = opts.sep[0]
 		}
 		sepKV := ":"
-		if len(sep) > 1 && sep[1] != "" {
-			sepKV = sep[1]
+		if len(opts.sep) > 1 && opts.sep[1] != "" {
+			sepKV = opts.sep[1]
 		}
 		kt := typ.Key()
 		vt := typ.Elem()
-		for _, elem := range strings.Split(dft, sepElem) {
-			kv := strings.Split(elem, sepKV)
+		// the 3rd separator splits elements of slice values
+		vopts := opts
+		if vt.Kind() == reflect.Slice && len(opts.sep) > 2 && opts.sep[2] != "" {
+			vopts.sep = opts.sep[2:]
+		}
+		for _, elem := range strings.Split(s, sepElem) {
+			// values may contain sepKV, like durations and datetimes
+			kv := strings.SplitN(elem, sepKV, 2)
 			if len(kv) != 2 {
-				return nil, fmt.Errorf("flagrouter: cannot convert %q to key value pair", elem)
+				return nil, fmt.Errorf("cannot convert %q to key value pair", elem)
 			}
-			key, err := parseDefault(kt, strings.TrimSpace(kv[0]), sep...)
+			key, err := parseValue(kt, strings.TrimSpace(kv[0]), opts)
 			if err != nil {
 				return nil, err
 			}
-			val, err := parseDefault(vt, strings.TrimSpace(kv[1]), sep...)
+			val, err := parseValue(vt, strings.TrimSpace(kv[1]), vopts)
 			if err != nil {
 				return nil, err
 			}
@@ -578,3 +2044,147 @@ func parseDefault(typ reflect.Type, dft string, sep ...string) (any, error) {
 		return m.Interface(), nil
 	}
 }
+
+// parseEmails parses s by mail.ParseAddressList to a slice of typ,
+// whose elements are *mail.Address or mail.Address.
+func parseEmails(typ reflect.Type, s string) (any, error) {
+	addrs, err := mail.ParseAddressList(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email address list %q: %w", s, err)
+	}
+	ls := reflect.MakeSlice(typ, 0, len(addrs))
+	for _, addr := range addrs {
+		if typ.Elem() == typEmailVal {
+			ls = reflect.Append(ls, reflect.ValueOf(*addr))
+		} else {
+			ls = reflect.Append(ls, reflect.ValueOf(addr))
+		}
+	}
+	return ls.Interface(), nil
+}
+
+// isBytes reports whether typ is []byte, or a named type of it.
+func isBytes(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8
+}
+
+// decodeBytes decodes s of encoding raw, hex or base64, base64 can be
+// the standard or URL encoding, padded or not.
+func decodeBytes(s, encoding string) ([]byte, error) {
+	switch encoding {
+	case "raw":
+		return []byte(s), nil
+	case "hex":
+		return hex.DecodeString(s)
+	}
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// encodeBytes is the reverse of decodeBytes.
+func encodeBytes(b []byte, encoding string) string {
+	switch encoding {
+	case "raw":
+		return string(b)
+	case "hex":
+		return hex.EncodeToString(b)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// isKVStruct reports whether typ is a struct parsed by parseStruct.
+func isKVStruct(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Struct || typ == typDateTime || typ == typEmailVal || isFlagValue(typ) {
+		return false
+	}
+	_, null := nullField(typ)
+	return !null
+}
+
+// parseStruct parses s like `host=a,port=1` to a struct of typ. Keys are long
+// names of fields, or lower case field names if fields have no long tag.
+// Separators are the same as map. Fields are parsed by their own tags,
+// and fields not in s are set to their defaults.
+func parseStruct(typ reflect.Type, s string, opts parseOpts) (any, error) {
+	sepElem := ","
+	if len(opts.sep) > 0 && opts.sep[0] != "" {
+		sepElem = opts.sep[0]
+	}
+	sepKV := "="
+	if len(opts.sep) > 1 && opts.sep[1] != "" {
+		sepKV = opts.sep[1]
+	}
+
+	val, params, err := newStruct(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, elem := range strings.Split(s, sepElem) {
+		if strings.TrimSpace(elem) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(elem, sepKV)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %q to key value pair", elem)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		i := slices.IndexFunc(params, func(p *param) bool { return p != nil && p.long == k })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown field %q of %v", k, typ)
+		}
+		p, field := params[i], val.Field(i)
+		if field.Kind() == reflect.String {
+			v = p.transform(v)
+		}
+		x, err := parseValue(field.Type(), v, p.opts())
+		if err != nil {
+			return nil, fmt.Errorf("field %q of %v: %w", k, typ, err)
+		}
+		field.Set(reflect.ValueOf(x).Convert(field.Type()))
+	}
+
+	return checkStruct(val, params)
+}
+
+// newStruct returns a struct of typ with fields set to their defaults,
+// and params of fields, which are nil for unexported fields.
+func newStruct(typ reflect.Type) (reflect.Value, []*param, error) {
+	val := reflect.New(typ).Elem()
+	params := make([]*param, typ.NumField())
+	for i := range params {
+		field := typ.Field(i)
+		if skip, err := skipField(field); err != nil {
+			return val, nil, err
+		} else if skip {
+			continue
+		}
+		p, err := parseTag(field)
+		if err != nil {
+			return val, nil, fmt.Errorf("field %v of %v: %w", field.Name, typ, err)
+		}
+		if p.long == flags.NoLong {
+			p.long = strings.ToLower(field.Name)
+		}
+		if p.dft != nil {
+			val.Field(i).Set(reflect.ValueOf(p.dft).Convert(field.Type))
+		}
+		params[i] = p
+	}
+	return val, params, nil
+}
+
+func checkStruct(val reflect.Value, params []*param) (any, error) {
+	for i, p := range params {
+		if p == nil {
+			continue
+		}
+		if err := p.check(val.Field(i)); err != nil {
+			return nil, fmt.Errorf("field %q of %v: %w", p.long, val.Type(), err)
+		}
+	}
+	return val.Interface(), nil
+}
@@ -1,7 +1,19 @@
 package flagrouter
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -127,6 +139,75 @@ func TestHandleOptions(t *testing.T) {
 	}
 }
 
+func TestFreshOptions(t *testing.T) {
+	r := New("fresh_options", "")
+
+	type opts struct {
+		Name   string   `short:"n" long:"name" dft:"guest"`
+		Tags   []string `short:"t" long:"tags" dft:"a,b"`
+		Levels []int    `long:"levels"`
+	}
+	var got []*opts
+	r.Handle(func(opt *opts) {
+		c := *opt
+		c.Tags = append([]string(nil), opt.Tags...)
+		got = append(got, &c, opt)
+		opt.Name = "modified"
+		opt.Tags[0] = "modified"
+	})
+
+	ctx := context.Background()
+	if _, err := r.Run(ctx, "-n", "admin", "--levels", "1", "--levels", "2"); err != nil {
+		t.Fatalf("fresh options: run 1: %v", err)
+	}
+	if _, err := r.Run(ctx, "-t", "x"); err != nil {
+		t.Fatalf("fresh options: run 2: %v", err)
+	}
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("fresh options: run 3: %v", err)
+	}
+	if len(got) != 6 {
+		t.Fatalf("fresh options: calls: %v", len(got))
+	}
+	if got[1] == got[3] {
+		t.Fatalf("fresh options: options shared by runs")
+	}
+
+	want := []opts{
+		{Name: "admin", Tags: []string{"a", "b"}, Levels: []int{1, 2}},
+		{Name: "guest", Tags: []string{"x"}},
+		{Name: "guest", Tags: []string{"a", "b"}},
+	}
+	for i, w := range want {
+		if o := got[i*2]; !reflect.DeepEqual(*o, w) {
+			t.Fatalf("fresh options: run %v: %+v", i+1, *o)
+		}
+	}
+}
+
+func TestFreshDefaults(t *testing.T) {
+	type opts struct {
+		Labels []map[string]string `long:"labels" dft:"a:1;b:2"`
+		Files  []string            `dft:"x,y"`
+	}
+	var got []string
+	r := New("fresh_defaults", "")
+	r.Handle(func(opt *opts) {
+		got = append(got, fmt.Sprint(opt.Labels, opt.Files))
+		opt.Labels[0]["a"] = "MUT"
+		opt.Files[0] = "MUT"
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Run(context.Background()); err != nil {
+			t.Fatalf("fresh defaults: run %v: %v", i+1, err)
+		}
+	}
+	if len(got) != 2 || got[0] != got[1] {
+		t.Fatalf("fresh defaults: %q", got)
+	}
+}
+
 func TestHandleContextOptions(t *testing.T) {
 	var bar any = 123
 
@@ -167,3 +248,2709 @@ func TestUseContext(t *testing.T) {
 		t.Fatalf("handle run: %v", err)
 	}
 }
+
+func TestPreRunPostRun(t *testing.T) {
+	var seq []string
+	r := New("hooks", "")
+	r.PreRun(func() { seq = append(seq, "pre1") })
+	r.PostRun(func() { seq = append(seq, "post1") })
+	r.Group("sub", "", func() {
+		r.PreRun(func() { seq = append(seq, "pre2") })
+		r.PostRun(func() { seq = append(seq, "post2") })
+		r.Use(func(next func()) {
+			seq = append(seq, "mw")
+			next()
+		})
+		r.Handle(func() { seq = append(seq, "handler") })
+	})
+	r.Handle(func() { seq = append(seq, "root") })
+
+	_, err := r.Run(context.Background(), "sub")
+	if err != nil {
+		t.Fatalf("hooks run: %v", err)
+	}
+	if got := strings.Join(seq, ","); got != "mw,pre1,pre2,handler,post2,post1" {
+		t.Fatalf("hooks: sub run sequence: %v", got)
+	}
+
+	seq = nil
+	_, err = r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("hooks run: %v", err)
+	}
+	if got := strings.Join(seq, ","); got != "pre1,root,post1" {
+		t.Fatalf("hooks: root run sequence: %v", got)
+	}
+}
+
+func TestDefer(t *testing.T) {
+	var seq []string
+	errFailed := errors.New("failed")
+	r := New("defer", "")
+	r.Use(func(next func()) {
+		seq = append(seq, "outer before")
+		next()
+		seq = append(seq, "outer after")
+	})
+	r.Stmt(func() {
+		r.Defer(func(err error) { seq = append(seq, fmt.Sprintf("deferred: %v", err)) })
+		r.Use(func(next func()) {
+			next()
+			seq = append(seq, "inner after")
+		})
+		r.Use(func() { seq = append(seq, "inner before") })
+		r.HandleGroup("ok", "", func() { seq = append(seq, "handler") })
+		r.HandleGroup("fail", "", func() error {
+			seq = append(seq, "handler")
+			return errFailed
+		})
+	})
+	r.HandleGroup("other", "", func() { seq = append(seq, "other") })
+
+	for _, c := range []struct {
+		cmd  string
+		want string
+	}{
+		{"ok", "outer before,inner before,handler,inner after,deferred: <nil>,outer after"},
+		{"fail", "outer before,inner before,handler,inner after,deferred: defer fail: failed,outer after"},
+		{"other", "outer before,other,outer after"},
+	} {
+		seq = nil
+		_, err := r.Run(context.Background(), c.cmd)
+		if c.cmd == "fail" && !errors.Is(err, errFailed) || c.cmd != "fail" && err != nil {
+			t.Fatalf("defer: run %v: %v", c.cmd, err)
+		}
+		if got := strings.Join(seq, ","); got != c.want {
+			t.Fatalf("defer: run %v sequence: %v", c.cmd, got)
+		}
+	}
+}
+
+type persistentOptions struct {
+	Level string `long:"log-level" dft:"info" persistent:"true"`
+}
+
+func TestPersistent(t *testing.T) {
+	var level string
+	r := New("persistent", "")
+	r.Use(func(opt *persistentOptions) { level = opt.Level })
+	r.Group("db", "", func() {
+		r.HandleGroup("migrate", "", func() {
+			if level != "debug" {
+				t.Fatalf("persistent: nested handler log level: %v", level)
+			}
+		})
+	})
+
+	for _, args := range [][]string{
+		{"db", "migrate", "--log-level", "debug"},
+		{"--log-level", "debug", "db", "migrate"},
+	} {
+		level = ""
+		_, err := r.Run(context.Background(), args...)
+		if err != nil {
+			t.Fatalf("persistent run %q: %v", args, err)
+		}
+	}
+}
+
+func TestPersistentExistingCmds(t *testing.T) {
+	var child struct {
+		Level string `long:"log-level"`
+	}
+	r := New("persistent", "")
+	r.HandleGroup("sub", "", func() {})
+	r.Group("own", "", func() {
+		r.Handle(func(opt *struct {
+			Level string `long:"log-level"`
+		}) {
+			child.Level = opt.Level
+		})
+	})
+
+	var opt *persistentOptions
+	r.Handle(func(o *persistentOptions) { opt = o })
+	_, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("persistent run: %v", err)
+	}
+
+	_, err = r.Run(context.Background(), "sub", "--log-level", "debug")
+	if err != nil {
+		t.Fatalf("persistent run sub: %v", err)
+	}
+	if level, _ := r.Value("log-level"); level != "debug" {
+		t.Fatalf("persistent: log level: %v", level)
+	}
+
+	_, err = r.Run(context.Background(), "own", "--log-level", "warn")
+	if err != nil {
+		t.Fatalf("persistent run own: %v", err)
+	}
+	_, err = r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("persistent run: %v", err)
+	}
+	if child.Level != "warn" || opt.Level != "info" {
+		t.Fatalf("persistent: redefined log level: %v, persistent: %v", child.Level, opt.Level)
+	}
+}
+
+func TestValue(t *testing.T) {
+	r := New("value", "")
+	r.HandleGroup("sub", "", func(opt *options) {})
+
+	_, err := r.Run(context.Background(), "sub", "-i", "456", "--list", "7", "--list", "8")
+	if err != nil {
+		t.Fatalf("value run: %v", err)
+	}
+
+	if val, set := r.Value("int"); val != 456 || !set {
+		t.Fatalf("value: int: %v, set: %v", val, set)
+	}
+	if val, set := r.Value("list"); !reflect.DeepEqual(val, []int{7, 8}) || !set {
+		t.Fatalf("value: list: %v, set: %v", val, set)
+	}
+	if val, set := r.Value("str"); val != "abc" || set {
+		t.Fatalf("value: str: %v, set: %v", val, set)
+	}
+	if val, set := r.Value("not-exists"); val != nil || set {
+		t.Fatalf("value: not exists: %v, set: %v", val, set)
+	}
+}
+
+func TestHandleError(t *testing.T) {
+	errFailed := errors.New("failed")
+	var got error
+	r := New("handle_error", "")
+	r.PostRun(func(err error) { got = err })
+	r.Handle(func(ctx context.Context) error { return errFailed })
+
+	_, err := r.Run(context.Background())
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("handle error: run: %v", err)
+	}
+	if !errors.Is(got, errFailed) {
+		t.Fatalf("handle error: post run: %v", got)
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"output", "sub", "-h"}
+
+	buf := new(bytes.Buffer)
+	r := New("output", "")
+	r.SetOutput(buf)
+	r.HandleGroup("sub", "the sub command", func() {
+		t.Fatalf("output: handler should not run")
+	})
+	r.RunCmdline(context.Background())
+
+	if !strings.HasPrefix(buf.String(), "output sub - the sub command\n\nUsage:") {
+		t.Fatalf("output: help: %q", buf.String())
+	}
+}
+
+func TestRunCmdlineE(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+
+	errFailed := errors.New("failed")
+	buf := new(bytes.Buffer)
+	r := New("cmdline", "")
+	r.SetOutput(buf)
+	r.Handle(func() {})
+	r.HandleGroup("fail", "", func() error { return errFailed })
+
+	os.Args = []string{"cmdline"}
+	if code, err := r.RunCmdlineE(context.Background()); code != 0 || err != nil {
+		t.Fatalf("cmdline: run: code: %v, err: %v", code, err)
+	}
+
+	os.Args = []string{"cmdline", "-h"}
+	if code, err := r.RunCmdlineE(context.Background()); code != 0 || !errors.Is(err, ErrHelp) {
+		t.Fatalf("cmdline: help: code: %v, err: %v", code, err)
+	}
+
+	buf.Reset()
+	os.Args = []string{"cmdline", "fail"}
+	if code, err := r.RunCmdlineE(context.Background()); code != 1 || !errors.Is(err, errFailed) {
+		t.Fatalf("cmdline: fail: code: %v, err: %v", code, err)
+	}
+	if buf.String() != "cmdline fail: failed\n" {
+		t.Fatalf("cmdline: fail output: %q", buf.String())
+	}
+}
+
+func TestCancelOnSignals(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"signal"}
+
+	proc, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("signal: find process: %v", err)
+	}
+
+	r := New("signal", "")
+	r.SetOutput(io.Discard)
+	r.CancelOnSignals(os.Interrupt)
+	r.Handle(func(ctx context.Context) error {
+		if err := proc.Signal(os.Interrupt); err != nil {
+			t.Skipf("signal: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return errors.New("not canceled")
+		}
+	})
+	if _, err := r.RunCmdlineE(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("signal: run: %v", err)
+	}
+
+	r = New("signal", "")
+	r.Handle(func(ctx context.Context) error { return ctx.Err() })
+	if _, err := r.RunCmdlineE(context.Background()); err != nil {
+		t.Fatalf("signal: run without signals: %v", err)
+	}
+}
+
+func TestResult(t *testing.T) {
+	type echo struct {
+		Msg string `dft:""`
+	}
+
+	r := New("result", "")
+	r.HandleGroup("echo", "", func(ctx context.Context, opt *echo) (string, error) {
+		if opt.Msg == "fail" {
+			return "partial", errors.New("failed")
+		}
+		return opt.Msg, nil
+	})
+	r.Group("wrap", "", func() {
+		r.Use(func(ctx context.Context, next func(context.Context)) {
+			next(ctx)
+			SetResult(ctx, "wrapped")
+		})
+		r.HandleGroup("echo", "", func(opt echo) (string, error) { return opt.Msg, nil })
+	})
+	r.HandleGroup("plain", "the plain cmd", func() {})
+
+	for _, c := range []struct {
+		args []string
+		want string
+		err  string
+	}{
+		{[]string{"echo", "hi"}, "hi", ""},
+		{[]string{"echo"}, "", ""},
+		{[]string{"echo", "fail"}, "partial", "result echo: failed"},
+		{[]string{"wrap", "echo", "hi"}, "wrapped", ""},
+	} {
+		got, err := r.Run(context.Background(), c.args...)
+		if got != c.want || c.err == "" && err != nil || c.err != "" && (err == nil || err.Error() != c.err) {
+			t.Fatalf("result: run %q: %q, %v", c.args, got, err)
+		}
+	}
+
+	if got, err := r.Run(context.Background(), "plain"); err != nil || !strings.HasPrefix(got, "result plain - the plain cmd") {
+		t.Fatalf("result: plain: %q, %v", got, err)
+	}
+
+	buf := new(bytes.Buffer)
+	r.SetOutput(buf)
+	if code := r.RunMain(context.Background(), "echo", "hi"); code != 0 || buf.String() != "hi\n" {
+		t.Fatalf("result: run main: %v, %q", code, buf.String())
+	}
+
+	defer func() {
+		if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), "a string and an error") {
+			t.Fatalf("result: expect panic, got %v", e)
+		}
+	}()
+	r.Handle(func() (error, string) { return nil, "" })
+}
+
+func TestRunMain(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	r := New("main", "")
+	r.SetOutput(&stdout)
+	r.errw = &stderr
+	r.RecoverPanics(true)
+	r.StopOnCancel(true)
+	r.Handle(func(*struct {
+		N int `short:"n" min:"0"`
+	}) {
+	})
+	r.Group("db", "", func() {
+		r.HandleGroup("migrate", "", func() error { return errors.New("failed") })
+		r.HandleGroup("crash", "", func() { panic("boom") })
+	})
+
+	ctx := context.Background()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	expired, cancel := context.WithDeadline(ctx, time.Now())
+	defer cancel()
+
+	for _, c := range []struct {
+		ctx    context.Context
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{ctx, nil, 0, "", ""},
+		{ctx, []string{"-h"}, 0, "main - \n", ""},
+		{ctx, []string{"db", "--help"}, 0, "main db - \n", ""},
+		{ctx, []string{"db", "migrate", "-h"}, 0, "main db migrate - \n", ""},
+		{ctx, []string{"help"}, 0, "main - \n", ""},
+		{ctx, []string{"db"}, 2, "", "no exec func of command main db\nmain db - \n"},
+		{ctx, []string{"db", "migrate"}, 1, "", "main db migrate: failed\n"},
+		{ctx, []string{"db", "crash"}, 1, "", "flagrouter: panic: boom\n"},
+		{canceled, []string{"db", "migrate"}, 1, "", context.Canceled.Error()},
+		{expired, []string{"db", "migrate"}, 1, "", context.DeadlineExceeded.Error()},
+		{ctx, []string{"-n", "x"}, 2, "", "parse option -n"},
+		{ctx, []string{"-n", "-1"}, 2, "", "field N"},
+		{ctx, []string{"--unknown"}, 2, "", "unknown"},
+	} {
+		stdout.Reset()
+		stderr.Reset()
+		if code := r.RunMain(c.ctx, c.args...); code != c.code {
+			t.Fatalf("run main %q: code %v, want %v, stderr: %v", c.args, code, c.code, stderr.String())
+		}
+		if !strings.HasPrefix(stdout.String(), c.stdout) || !strings.Contains(stderr.String(), c.stderr) ||
+			(c.stdout == "") != (stdout.Len() == 0) || (c.stderr == "") != (stderr.Len() == 0) {
+			t.Fatalf("run main %q: stdout: %q, stderr: %q", c.args, stdout.String(), stderr.String())
+		}
+	}
+}
+
+func TestBoolWords(t *testing.T) {
+	type boolOptions struct {
+		Color  bool            `long:"color" dft:"Yes"`
+		Quiet  bool            `long:"quiet" dft:"off"`
+		Bools  []bool          `long:"bools" dft:"on,NO,true,0"`
+		Switch map[string]bool `long:"switch" dft:"a:on,b:off"`
+	}
+	var got boolOptions
+	r := New("bools", "")
+	r.Handle(func(opt boolOptions) { got = opt })
+
+	ctx := context.Background()
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("bool words: run: %v", err)
+	}
+	want := boolOptions{Color: true, Bools: []bool{true, false, true, false}, Switch: map[string]bool{"a": true, "b": false}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bool words: default: %+v", got)
+	}
+
+	if _, err := r.Run(ctx, "--quiet", "--bools", "off", "--bools", "YES", "--switch", "c:on"); err != nil {
+		t.Fatalf("bool words: run: %v", err)
+	}
+	want = boolOptions{Color: true, Quiet: true, Bools: []bool{false, true}, Switch: map[string]bool{"c": true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bool words: flags: %+v", got)
+	}
+
+	if _, err := r.Run(ctx, "--color=off", "--quiet=on", "--bools=Off", "--switch=d:yes"); err != nil {
+		t.Fatalf("bool words: inline: %v", err)
+	}
+	want = boolOptions{Quiet: true, Bools: []bool{false}, Switch: map[string]bool{"d": true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bool words: inline: %+v", got)
+	}
+
+	_, err := r.Run(ctx, "--bools", "maybe")
+	if err == nil || !strings.Contains(err.Error(), `invalid bool "maybe"`) {
+		t.Fatalf("bool words: invalid: %v", err)
+	}
+}
+
+func TestBoolForms(t *testing.T) {
+	type boolForms struct {
+		Verbose bool        `short:"v" long:"verbose"`
+		Quiet   bool        `short:"q" long:"quiet" dft:"true"`
+		Debug   switchValue `short:"d"`
+		Num     int         `short:"n"`
+		Name    string      `short:"s"`
+	}
+
+	var got boolForms
+	r := New("bools", "")
+	r.Handle(func(opt boolForms) { got = opt })
+
+	for _, c := range []struct {
+		args []string
+		want boolForms
+	}{
+		{[]string{"--verbose"}, boolForms{Verbose: true, Quiet: true}},
+		{[]string{"--verbose=false", "--quiet=false"}, boolForms{}},
+		{[]string{"--verbose=1", "--quiet=0"}, boolForms{Verbose: true}},
+		{[]string{"--verbose=yes", "--quiet=FALSE"}, boolForms{Verbose: true}},
+		{[]string{"--verbose=FALSE"}, boolForms{Quiet: true}},
+		{[]string{"-v"}, boolForms{Verbose: true, Quiet: true}},
+		{[]string{"-v=true", "-q=false"}, boolForms{Verbose: true}},
+		{[]string{"-vd"}, boolForms{Verbose: true, Quiet: true, Debug: "on"}},
+		{[]string{"-q=no", "-vd=false"}, boolForms{Verbose: true, Debug: "off"}},
+		{[]string{"-vn", "5"}, boolForms{Verbose: true, Quiet: true, Num: 5}},
+		{[]string{"-vn5", "-s=a=b"}, boolForms{Verbose: true, Quiet: true, Num: 5, Name: "a=b"}},
+		{[]string{"-vn=0x10", "-sabc"}, boolForms{Verbose: true, Quiet: true, Num: 16, Name: "abc"}},
+	} {
+		if _, err := r.Run(context.Background(), c.args...); err != nil {
+			t.Fatalf("bool forms: %q: %v", c.args, err)
+		}
+		if got != c.want {
+			t.Fatalf("bool forms: %q: %+v, want %+v", c.args, got, c.want)
+		}
+	}
+
+	for _, args := range [][]string{{"-v=maybe"}, {"--verbose=maybe"}, {"-vx"}, {"-nv"}} {
+		if _, err := r.Run(context.Background(), args...); err == nil {
+			t.Fatalf("bool forms: %q: expect error", args)
+		}
+	}
+}
+
+func TestSliceReplacesDefault(t *testing.T) {
+	type tags struct {
+		Tags  []string        `long:"tag" dft:"a,b"`
+		Ports []int           `long:"port" dft:"80,443"`
+		Waits []time.Duration `long:"wait" dft:"1s"`
+	}
+
+	var got tags
+	r := New("replace", "")
+	r.Handle(func(opt tags) { got = opt })
+
+	for _, c := range []struct {
+		args []string
+		want tags
+	}{
+		{nil, tags{[]string{"a", "b"}, []int{80, 443}, []time.Duration{time.Second}}},
+		{[]string{"--tag", "x", "--port", "8080"},
+			tags{[]string{"x"}, []int{8080}, []time.Duration{time.Second}}},
+		{[]string{"--tag", "x", "--tag=y", "--port", "1,2", "--port", "3", "--wait", "2s", "--wait", "3s"},
+			tags{[]string{"x", "y"}, []int{1, 2, 3}, []time.Duration{2 * time.Second, 3 * time.Second}}},
+		{nil, tags{[]string{"a", "b"}, []int{80, 443}, []time.Duration{time.Second}}},
+	} {
+		if _, err := r.Run(context.Background(), c.args...); err != nil {
+			t.Fatalf("slice replaces default: run %q: %v", c.args, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("slice replaces default: run %q: %+v", c.args, got)
+		}
+	}
+}
+
+func TestMergeTag(t *testing.T) {
+	type merged struct {
+		Include []string         `long:"include" dft:"/usr/include" merge:"append"`
+		Exclude []string         `long:"exclude" dft:"vendor" merge:"replace"`
+		Tags    map[string]int   `long:"tag" dft:"a:1,b:2" merge:"append"`
+		Groups  map[string][]int `long:"group" dft:"a:1" merge:"append"`
+		Libs    []string         `long:"lib" dft:"c"`
+	}
+
+	var got merged
+	r := New("merge", "")
+	r.Handle(func(opt merged) { got = opt })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("merge tag: run: %v", err)
+	}
+	want := merged{
+		Include: []string{"/usr/include"},
+		Exclude: []string{"vendor"},
+		Tags:    map[string]int{"a": 1, "b": 2},
+		Groups:  map[string][]int{"a": {1}},
+		Libs:    []string{"c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("merge tag: defaults: %+v", got)
+	}
+
+	_, err := r.Run(context.Background(), "--include", "./inc", "--include=./lib", "--exclude", "tmp",
+		"--tag", "b:3,c:4", "--group", "a:2,b:3", "--lib", "m")
+	if err != nil {
+		t.Fatalf("merge tag: run: %v", err)
+	}
+	want = merged{
+		Include: []string{"/usr/include", "./inc", "./lib"},
+		Exclude: []string{"tmp"},
+		Tags:    map[string]int{"a": 1, "b": 3, "c": 4},
+		Groups:  map[string][]int{"a": {1, 2}, "b": {3}},
+		Libs:    []string{"m"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("merge tag: %+v", got)
+	}
+
+	for _, opt := range []any{
+		func(*struct {
+			N int `long:"n" merge:"append"`
+		}) {
+		},
+		func(*struct {
+			L []int `long:"l" merge:"prepend"`
+		}) {
+		},
+	} {
+		func() {
+			defer func() {
+				if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), "merge tag") {
+					t.Fatalf("merge tag: expect panic, got %v", e)
+				}
+			}()
+			New("merge", "").Handle(opt)
+		}()
+	}
+}
+
+func TestUnitTag(t *testing.T) {
+	type units struct {
+		Interval time.Duration            `long:"interval" unit:"ms" dft:"500"`
+		Timeout  time.Duration            `long:"timeout" unit:"s" dft:"1m"`
+		Retries  []time.Duration          `long:"retry" unit:"ms"`
+		Limits   map[string]time.Duration `long:"limit" unit:"h"`
+	}
+
+	var got units
+	r := New("unit", "")
+	r.Handle(func(opt units) { got = opt })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("unit tag: run: %v", err)
+	}
+	if got.Interval != 500*time.Millisecond || got.Timeout != time.Minute {
+		t.Fatalf("unit tag: defaults: %+v", got)
+	}
+
+	_, err := r.Run(context.Background(), "--interval", "250", "--timeout=1.5",
+		"--retry", "10,20", "--retry", "1s", "--limit", "a:2,b:30m")
+	if err != nil {
+		t.Fatalf("unit tag: run: %v", err)
+	}
+	want := units{
+		Interval: 250 * time.Millisecond,
+		Timeout:  1500 * time.Millisecond,
+		Retries:  []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, time.Second},
+		Limits:   map[string]time.Duration{"a": 2 * time.Hour, "b": 30 * time.Minute},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unit tag: %+v", got)
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, "--interval duration, unit: ms (default: 500ms)") {
+		t.Fatalf("unit tag: usage:\n%v", usage)
+	}
+
+	for _, opt := range []any{
+		func(*struct {
+			N int `long:"n" unit:"ms"`
+		}) {
+		},
+		func(*struct {
+			D time.Duration `long:"d" unit:"d"`
+		}) {
+		},
+	} {
+		func() {
+			defer func() {
+				if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), "unit tag") {
+					t.Fatalf("unit tag: expect panic, got %v", e)
+				}
+			}()
+			New("unit", "").Handle(opt)
+		}()
+	}
+}
+
+func TestIntBases(t *testing.T) {
+	type baseOptions struct {
+		Dec  int          `long:"dec" dft:"42"`
+		Hex  int          `long:"hex" dft:"0x1F"`
+		Oct  uint32       `long:"oct" dft:"0o755"`
+		Bin  uint8        `long:"bin" dft:"0b1010"`
+		Neg  int64        `long:"neg" dft:"-0x10"`
+		List []int        `long:"list" dft:"0x10,0b11,7"`
+		Map  map[int]uint `long:"map" dft:"0x1:0o10"`
+	}
+	var got baseOptions
+	r := New("bases", "")
+	r.Handle(func(opt baseOptions) { got = opt })
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("int bases: run: %v", err)
+	}
+	want := baseOptions{Dec: 42, Hex: 31, Oct: 0755, Bin: 10, Neg: -16, List: []int{16, 3, 7}, Map: map[int]uint{1: 8}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("int bases: %+v", got)
+	}
+
+	_, err := r.Run(context.Background(), "--dec", "-12", "--hex=-0xff", "--oct", "0o17", "--bin", "0b11111111",
+		"--neg", "0x7fffffffffffffff", "--list", "0b1", "--list=0o7", "--map", "0x2:0xA")
+	if err != nil {
+		t.Fatalf("int bases: run with args: %v", err)
+	}
+	want = baseOptions{Dec: -12, Hex: -255, Oct: 017, Bin: 255, Neg: 1<<63 - 1, List: []int{1, 7}, Map: map[int]uint{2: 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("int bases: args: %+v", got)
+	}
+
+	for _, args := range [][]string{{"--bin", "0x100"}, {"--oct", "-0o1"}, {"--dec", "0xg"}} {
+		if _, err = r.Run(context.Background(), args...); err == nil {
+			t.Fatalf("int bases: %q: expect error", args)
+		}
+	}
+}
+
+func TestRunWithTimeout(t *testing.T) {
+	r := New("app", "")
+	r.HandleGroup("wait", "", func(ctx context.Context) error {
+		<-ctx.Done()
+		return fmt.Errorf("wait: %w", ctx.Err())
+	})
+	r.HandleGroup("slow", "", func(ctx context.Context) {
+		time.Sleep(20 * time.Millisecond)
+	})
+	r.HandleGroup("fast", "", func(ctx context.Context) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Fatalf("run with timeout: no deadline")
+		}
+	})
+
+	ctx := context.Background()
+	_, err := r.RunWithTimeout(ctx, 10*time.Millisecond, "wait")
+	var cmdErr *CmdError
+	if !errors.As(err, &cmdErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("run with timeout: wait: %v", err)
+	}
+	if _, err = r.RunWithTimeout(ctx, time.Millisecond, "slow"); err != context.DeadlineExceeded {
+		t.Fatalf("run with timeout: slow: %v", err)
+	}
+	if _, err = r.RunWithTimeout(ctx, time.Second, "fast"); err != nil {
+		t.Fatalf("run with timeout: fast: %v", err)
+	}
+}
+
+func TestStopOnCancel(t *testing.T) {
+	var calls []string
+	r := New("app", "")
+	r.Use(func(ctx context.Context, next func(context.Context)) {
+		calls = append(calls, "mw1")
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		next(ctx)
+	})
+	r.Use(func() { calls = append(calls, "mw2") })
+	r.Handle(func() { calls = append(calls, "handler") })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("stop on cancel: disabled: %v", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"mw1", "mw2", "handler"}) {
+		t.Fatalf("stop on cancel: disabled: calls: %q", calls)
+	}
+
+	r.StopOnCancel(true)
+	calls = nil
+	if _, err := r.Run(context.Background()); err != context.Canceled {
+		t.Fatalf("stop on cancel: %v", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"mw1"}) {
+		t.Fatalf("stop on cancel: calls: %q", calls)
+	}
+
+	calls = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.Run(ctx); err != context.Canceled || len(calls) != 0 {
+		t.Fatalf("stop on cancel: canceled before run: %v, calls: %q", err, calls)
+	}
+}
+
+func TestAutoShort(t *testing.T) {
+	type autoOpts struct {
+		Verbose bool   `long:"verbose"`
+		Version bool   `long:"version"`
+		Host    string `long:"host"`
+		Level   int    `long:"level"`
+		Lines   int    `short:"l" long:"lines"`
+		VV      bool   `long:"vvv"`
+		Dry     bool   `long:"dry-run"`
+	}
+
+	var got autoOpts
+	r := New("auto", "")
+	r.AutoShort(true)
+	r.Handle(func(opt autoOpts) { got = opt })
+
+	usage, _ := r.Run(context.Background(), "-h")
+	for _, line := range []string{
+		"-v, --verbose bool", "-e, --version bool", "-o, --host string",
+		"  --level int", "-l, --lines int", "  --vvv bool", "-d, --dry-run bool",
+	} {
+		if !strings.Contains(usage, line) {
+			t.Fatalf("auto short: %q not in usage:\n%v", line, usage)
+		}
+	}
+
+	if _, err := r.Run(context.Background(), "-ve", "-o", "a", "-l", "3", "-d"); err != nil {
+		t.Fatalf("auto short: run: %v", err)
+	}
+	want := autoOpts{Verbose: true, Version: true, Host: "a", Lines: 3, Dry: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("auto short: %+v", got)
+	}
+
+	usage2, _ := r.Clone().Run(context.Background(), "-h")
+	if usage2 != usage {
+		t.Fatalf("auto short: clone usage:\n%v", usage2)
+	}
+}
+
+func TestPassthroughUnknown(t *testing.T) {
+	type wrapped struct {
+		Verbose bool     `short:"v" long:"verbose"`
+		Args    []string `placeholder:"ARG"`
+	}
+
+	var got wrapped
+	r := New("wrap", "")
+	r.HandleGroup("git", "", func(opt wrapped) { got = opt })
+	r.HandleGroup("noargs", "", func() {})
+
+	args := []string{"git", "log", "--oneline", "-v", "-n", "3", "--format=%h", "--", "--verbose"}
+	if _, err := r.Run(context.Background(), args...); err == nil {
+		t.Fatalf("passthrough: disabled: expect error")
+	}
+
+	r.PassthroughUnknown(true)
+	if _, err := r.Run(context.Background(), args...); err != nil {
+		t.Fatalf("passthrough: run: %v", err)
+	}
+	want := wrapped{Verbose: true, Args: []string{"log", "--oneline", "-n", "3", "--format=%h", "--verbose"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("passthrough: %+v", got)
+	}
+
+	if _, err := r.Run(context.Background(), "git", "--help"); !errors.Is(err, ErrHelp) {
+		t.Fatalf("passthrough: help: %v", err)
+	}
+	if _, err := r.Run(context.Background(), "noargs", "--unknown"); err == nil {
+		t.Fatalf("passthrough: no positionals: expect error")
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	var deferred bool
+	r := New("app", "")
+	r.Defer(func() { deferred = true })
+	r.HandleGroup("value", "", func() { panic("boom") })
+	r.HandleGroup("error", "", func() { panic(io.ErrUnexpectedEOF) })
+	r.Group("mw", "", func() {
+		r.Use(func() { panic("middleware") })
+		r.Handle(func() {})
+	})
+
+	func() {
+		defer func() {
+			if e := recover(); e != "boom" {
+				t.Fatalf("recover panics: disabled: %v", e)
+			}
+		}()
+		r.Run(context.Background(), "value")
+	}()
+
+	r.RecoverPanics(true)
+	deferred = false
+	_, err := r.Run(context.Background(), "value")
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Value() != "boom" || err.Error() != "flagrouter: panic: boom" {
+		t.Fatalf("recover panics: %v", err)
+	}
+	if !strings.Contains(string(pe.Stack()), "TestRecoverPanics") || !deferred {
+		t.Fatalf("recover panics: stack:\n%s\ndeferred: %v", pe.Stack(), deferred)
+	}
+
+	if _, err = r.Run(context.Background(), "error"); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("recover panics: error value: %v", err)
+	}
+	if _, err = r.Run(context.Background(), "mw"); !errors.As(err, &pe) || pe.Value() != "middleware" {
+		t.Fatalf("recover panics: middleware: %v", err)
+	}
+}
+
+func TestHandleWith(t *testing.T) {
+	var calls []string
+	r := New("app", "")
+	r.Use(func() { calls = append(calls, "use") })
+	r.PreRun(func() { calls = append(calls, "pre") })
+	r.Group("a", "", func() {
+		r.HandleWith(func() { calls = append(calls, "a") },
+			func(next func()) {
+				calls = append(calls, "mw1")
+				next()
+				calls = append(calls, "mw1 done")
+			},
+			func(opt *struct {
+				Name string `long:"name"`
+			}) {
+				calls = append(calls, "mw2 "+opt.Name)
+			})
+	})
+	r.HandleGroup("b", "", func() { calls = append(calls, "b") })
+
+	if _, err := r.Run(context.Background(), "a", "--name", "x"); err != nil {
+		t.Fatalf("handle with: run a: %v", err)
+	}
+	want := []string{"use", "mw1", "mw2 x", "pre", "a", "mw1 done"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("handle with: a: %q", calls)
+	}
+
+	calls = nil
+	if _, err := r.Run(context.Background(), "b"); err != nil {
+		t.Fatalf("handle with: run b: %v", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"use", "pre", "b"}) {
+		t.Fatalf("handle with: b: %q", calls)
+	}
+}
+
+func TestDefaultSub(t *testing.T) {
+	var calls []string
+	r := New("app", "")
+	r.Group("remote", "", func() {
+		r.Use(func(*struct {
+			Verbose bool `short:"v" long:"verbose" persistent:"true"`
+		}) {
+		})
+		r.HandleGroup("show", "", func(opt *struct {
+			Name []string
+		}) {
+			calls = append(calls, fmt.Sprint("show ", opt.Name))
+		})
+		r.HandleGroup("add", "", func(opt *struct {
+			Name string
+		}) {
+			calls = append(calls, "add "+opt.Name)
+		})
+		r.DefaultSub("show")
+	})
+
+	ctx := context.Background()
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"remote"}, "show []"},
+		{[]string{"remote", "-v"}, "show []"},
+		{[]string{"remote", "origin"}, "show [origin]"},
+		{[]string{"remote", "-v", "--", "add"}, "show [add]"},
+		{[]string{"remote", "add", "origin"}, "add origin"},
+		{[]string{"remote", "show", "add"}, "show [add]"},
+	} {
+		calls = nil
+		if _, err := r.Run(ctx, c.args...); err != nil {
+			t.Fatalf("default sub: %q: %v", c.args, err)
+		}
+		if !reflect.DeepEqual(calls, []string{c.want}) {
+			t.Fatalf("default sub: %q: %q", c.args, calls)
+		}
+	}
+	if v, set := r.Value("verbose"); v != false || set {
+		t.Fatalf("default sub: verbose: %v, %v", v, set)
+	}
+	if _, err := r.Run(ctx, "remote", "-v"); err != nil {
+		t.Fatalf("default sub: run: %v", err)
+	}
+	if v, set := r.Value("verbose"); v != true || !set {
+		t.Fatalf("default sub: verbose set: %v, %v", v, set)
+	}
+
+	usage, err := r.Run(ctx, "remote", "-h")
+	if !errors.Is(err, ErrHelp) || !strings.HasPrefix(usage, "app remote - ") ||
+		!strings.Contains(usage, "  show (default)\n") {
+		t.Fatalf("default sub: help: %v\n%v", err, usage)
+	}
+
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatalf("default sub: expect panic for unknown subcommand")
+		}
+	}()
+	r.Group("x", "", func() { r.DefaultSub("missing") })
+}
+
+func TestExecute(t *testing.T) {
+	var name string
+	r := New("app", "")
+	r.HandleGroup("hello", "", func(opt *struct {
+		Name string `long:"name"`
+	}) error {
+		name = opt.Name
+		if name == "" {
+			return errors.New("no name")
+		}
+		return nil
+	})
+	var buf strings.Builder
+	r.SetOutput(&buf)
+
+	args := os.Args
+	defer func() { os.Args = args }()
+
+	os.Args = []string{"app", "hello", "--name", "x"}
+	if err := r.Execute(); err != nil || name != "x" {
+		t.Fatalf("execute: %v, name: %v", err, name)
+	}
+
+	os.Args = []string{"app", "-h"}
+	if err := r.Execute(); err != nil || !strings.HasPrefix(buf.String(), "app - ") {
+		t.Fatalf("execute: help: %v\n%v", err, buf.String())
+	}
+
+	buf.Reset()
+	os.Args = []string{"app", "hello"}
+	var cmdErr *CmdError
+	if err := r.Execute(); !errors.As(err, &cmdErr) || buf.String() != "app hello: no name\n" {
+		t.Fatalf("execute: error: %v, output: %q", err, buf.String())
+	}
+}
+
+func TestResolve(t *testing.T) {
+	var calls []string
+	r := New("app", "")
+	r.Use(func() { calls = append(calls, "mw") })
+	r.Group("db", "", func() {
+		r.PreRun(func() { calls = append(calls, "pre") })
+		r.HandleGroup("migrate", "", func(*struct {
+			Steps int    `long:"steps" dft:"1" min:"1"`
+			DSN   string `long:"dsn" dft:"local"`
+		}) {
+			calls = append(calls, "migrate")
+		})
+	})
+
+	ctx := context.Background()
+	path, err := r.Resolve(ctx, "db", "migrate", "--steps", "3")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if !reflect.DeepEqual(path, []string{"app", "db", "migrate"}) {
+		t.Fatalf("resolve: path: %q", path)
+	}
+	if len(calls) != 0 {
+		t.Fatalf("resolve: calls: %q", calls)
+	}
+	if steps, _ := r.Value("steps"); steps != 3 {
+		t.Fatalf("resolve: steps: %v", steps)
+	}
+	if dsn, _ := r.Value("dsn"); dsn != "local" {
+		t.Fatalf("resolve: dsn: %v", dsn)
+	}
+
+	path, err = r.Resolve(ctx, "db", "migrate", "--steps", "0")
+	if err == nil || !strings.Contains(err.Error(), "field Steps") || len(path) != 3 {
+		t.Fatalf("resolve: invalid: %q, %v", path, err)
+	}
+	if _, err = r.Resolve(ctx, "db", "-h"); !errors.Is(err, ErrHelp) {
+		t.Fatalf("resolve: help: %v", err)
+	}
+
+	if _, err = r.Run(ctx, "db", "migrate"); err != nil {
+		t.Fatalf("resolve: run: %v", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"mw", "pre", "migrate"}) {
+		t.Fatalf("resolve: calls of run: %q", calls)
+	}
+}
+
+func TestDefaultOverflow(t *testing.T) {
+	for _, c := range []struct {
+		typ reflect.Type
+		dft string
+		ok  bool
+	}{
+		{reflect.TypeOf(int8(0)), "127", true},
+		{reflect.TypeOf(int8(0)), "-128", true},
+		{reflect.TypeOf(int8(0)), "128", false},
+		{reflect.TypeOf(int8(0)), "300", false},
+		{reflect.TypeOf(int8(0)), "-129", false},
+		{reflect.TypeOf(int16(0)), "32767", true},
+		{reflect.TypeOf(int16(0)), "32768", false},
+		{reflect.TypeOf(int32(0)), "-2147483648", true},
+		{reflect.TypeOf(int32(0)), "-2147483649", false},
+		{reflect.TypeOf(uint8(0)), "255", true},
+		{reflect.TypeOf(uint8(0)), "256", false},
+		{reflect.TypeOf(uint16(0)), "0xFFFF", true},
+		{reflect.TypeOf(uint16(0)), "0x10000", false},
+		{reflect.TypeOf(uint32(0)), "4294967295", true},
+		{reflect.TypeOf(uint32(0)), "4294967296", false},
+		{reflect.TypeOf([]int8{}), "1,200", false},
+	} {
+		field := reflect.StructField{
+			Name: "N",
+			Type: c.typ,
+			Tag:  reflect.StructTag(`long:"n" dft:"` + c.dft + `"`),
+		}
+		p, err := parseTag(field)
+		if c.ok {
+			if err != nil {
+				t.Fatalf("default overflow: %v %v: %v", c.typ, c.dft, err)
+			}
+			want, _ := strconv.ParseInt(c.dft, 0, 64)
+			if got := reflect.ValueOf(p.dft).Convert(reflect.TypeOf(want)).Int(); got != want {
+				t.Fatalf("default overflow: %v %v: got %v", c.typ, c.dft, got)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "overflows") {
+			t.Fatalf("default overflow: %v %v: expect overflow error, got %v", c.typ, c.dft, err)
+		}
+	}
+}
+
+func TestIntBitSize(t *testing.T) {
+	type sized struct {
+		Small int8     `long:"small" dft:"-1"`
+		Port  uint16   `long:"port" dft:"65535"`
+		Masks []uint8  `long:"mask" sep:"|" dft:"0xFF|0x0F"`
+		Codes []int16  `long:"code"`
+		Big   uint64   `long:"big" dft:"18446744073709551615"`
+		Neg   []int32  `long:"neg" dft:"-2147483648"`
+		Perms []uint32 `long:"perm"`
+	}
+	var got sized
+	r := New("bit_size", "")
+	r.Handle(func(opt sized) { got = opt })
+
+	ctx := context.Background()
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("bit size: run: %v", err)
+	}
+	want := sized{Small: -1, Port: 65535, Masks: []uint8{0xFF, 0x0F}, Big: 1<<64 - 1, Neg: []int32{-1 << 31}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bit size: defaults: %+v", got)
+	}
+
+	if _, err := r.Run(ctx, "--port", "65534", "--mask", "1|2", "--small", "127"); err != nil {
+		t.Fatalf("bit size: run: %v", err)
+	}
+	if got.Port != 65534 || got.Small != 127 || !reflect.DeepEqual(got.Masks, []uint8{1, 2}) {
+		t.Fatalf("bit size: args: %+v", got)
+	}
+
+	for _, args := range [][]string{
+		{"--small", "128"},
+		{"--port", "65536"},
+		{"--mask", "1|256"},
+		{"--code", "40000"},
+	} {
+		if _, err := r.Run(ctx, args...); err == nil || !strings.Contains(err.Error(), "overflow") {
+			t.Fatalf("bit size %q: expect overflow error, got %v", args, err)
+		}
+	}
+
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			if !errors.Is(err, strconv.ErrRange) || !strings.Contains(err.Error(), "value 300 overflows int8") {
+				t.Fatalf("bit size: default overflow: %v", err)
+			}
+		}()
+		New("bit_size", "").Handle(func(*struct {
+			N int8 `long:"n" dft:"300"`
+		}) {
+		})
+	}()
+}
+
+func TestFieldErrors(t *testing.T) {
+	type inner struct {
+		Port int `long:"port" dft:"x"`
+	}
+	for _, c := range []struct {
+		handler any
+		err     string
+	}{
+		{func(struct {
+			Name string `long:"name"`
+			Ch   chan int
+		}) {
+		}, "flagrouter: field Ch of struct { Name string \"long:\\\"name\\\"\"; Ch chan int }: unsupported type: chan int"},
+		{func(*struct {
+			Port int `long:"port" dft:"http"`
+		}) {
+		}, `field Port of struct { Port int "long:\"port\" dft:\"http\"" }: dft tag "http": strconv.ParseInt`},
+		{func(*struct {
+			Wait time.Duration `long:"wait" dft:"1x"`
+		}) {
+		}, `field Wait of`},
+		{func(*struct {
+			Servers []inner `long:"server" dft:"port=1"`
+		}) {
+		}, `dft tag "port=1": field Port of flagrouter.inner: dft tag "x": strconv.ParseInt`},
+		{func(*struct {
+			N int `short:"nn"`
+		}) {
+		}, `field N of struct { N int "short:\"nn\"" }: invalid short tag "nn"`},
+	} {
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("field errors: %v, want %v", err, c.err)
+				}
+			}()
+			New("errors", "").Handle(c.handler)
+		}()
+	}
+}
+
+func TestPosition(t *testing.T) {
+	type position struct {
+		Name  string
+		Count int `dft:"1"`
+		Rest  []string
+	}
+
+	var opt *position
+	r := New("position", "")
+	r.HandleGroup("sub", "", func(o *position) { opt = o })
+
+	_, err := r.Run(context.Background(), "sub", "foo", "3", "a", "b")
+	if err != nil {
+		t.Fatalf("position run: %v", err)
+	}
+	if opt.Name != "foo" || opt.Count != 3 || !reflect.DeepEqual(opt.Rest, []string{"a", "b"}) {
+		t.Fatalf("position: %+v", *opt)
+	}
+
+	_, err = r.Run(context.Background(), "sub", "bar")
+	if err != nil {
+		t.Fatalf("position run: %v", err)
+	}
+	if opt.Name != "bar" || opt.Count != 1 || opt.Rest != nil {
+		t.Fatalf("position: defaults: %+v", *opt)
+	}
+
+	_, err = r.Run(context.Background(), "sub", "foo", "x")
+	if err == nil {
+		t.Fatalf("position: invalid int: no error")
+	}
+}
+
+func TestPositionalCount(t *testing.T) {
+	r := New("count", "")
+	r.HandleGroup("cp", "", func(*struct {
+		Src string `required:"true"`
+		Dst string `required:"true"`
+	}) {
+	})
+	r.HandleGroup("echo", "", func(*struct {
+		Msg   string
+		Times int `dft:"1"`
+	}) {
+	})
+	r.HandleGroup("ls", "", func(*struct {
+		Dirs []string
+	}) {
+	})
+	r.HandleGroup("mv", "", func(*struct {
+		Src  string
+		Dst  string
+		More []string
+	}) {
+	})
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"cp", "a", "b"}, ""},
+		{[]string{"cp", "a", "b", "c"}, "count cp: expected 2 arguments, got 3"},
+		{[]string{"cp", "a"}, "count cp: expected 2 arguments, got 1"},
+		{[]string{"echo"}, "count echo: expected at least 1 arguments, got 0"},
+		{[]string{"echo", "hi"}, ""},
+		{[]string{"echo", "hi", "2"}, ""},
+		{[]string{"echo", "hi", "2", "x"}, "count echo: expected at most 2 arguments, got 3"},
+		{[]string{"ls", "a", "b", "c"}, ""},
+		{[]string{"ls"}, ""},
+		{[]string{"mv", "a"}, "count mv: expected at least 2 arguments, got 1"},
+		{[]string{"mv", "a", "b"}, ""},
+		{[]string{"mv", "a", "b", "c", "d"}, ""},
+	} {
+		_, err := r.Run(context.Background(), c.args...)
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Fatalf("positional count: run %q: %v, want %q", c.args, err, c.err)
+		}
+	}
+}
+
+func TestInterspersedFlags(t *testing.T) {
+	type interspersed struct {
+		Bar  bool `long:"bar"`
+		Args []string
+	}
+
+	for _, c := range []struct {
+		interspersed bool
+		bar          bool
+		args         []string
+	}{
+		{true, true, []string{"foo"}},
+		{false, false, []string{"foo", "--bar"}},
+	} {
+		var opt *interspersed
+		r := New("interspersed", "")
+		r.InterspersedFlags(c.interspersed)
+		r.Handle(func(o *interspersed) { opt = o })
+
+		_, err := r.Run(context.Background(), "foo", "--bar")
+		if err != nil {
+			t.Fatalf("interspersed %v: run: %v", c.interspersed, err)
+		}
+		if opt.Bar != c.bar || !reflect.DeepEqual(opt.Args, c.args) {
+			t.Fatalf("interspersed %v: %+v", c.interspersed, *opt)
+		}
+	}
+}
+
+func TestTerminator(t *testing.T) {
+	type terminated struct {
+		Bar  bool `long:"bar"`
+		Args []string
+	}
+
+	var opt *terminated
+	r := New("terminator", "")
+	r.Group("sub", "", func() {
+		r.Handle(func(o *terminated) { opt = o })
+	})
+
+	_, err := r.Run(context.Background(), "sub", "a", "--", "--not-a-flag", "--bar", "sub", "--")
+	if err != nil {
+		t.Fatalf("terminator: run: %v", err)
+	}
+	want := []string{"a", "--not-a-flag", "--bar", "sub", "--"}
+	if opt.Bar || !reflect.DeepEqual(opt.Args, want) {
+		t.Fatalf("terminator: %+v", *opt)
+	}
+}
+
+func TestOut(t *testing.T) {
+	r := New("out", "")
+	r.Use(func(ctx context.Context, next func(context.Context)) {
+		fmt.Fprint(Out(ctx), "before ")
+		next(ctx)
+	})
+	r.Handle(func(ctx context.Context, opt *struct {
+		Name string
+	}) {
+		fmt.Fprintf(Out(ctx), "hello %v\n", opt.Name)
+	})
+
+	buf := new(bytes.Buffer)
+	r.SetOutput(buf)
+	if _, err := r.Run(context.Background(), "alice"); err != nil {
+		t.Fatalf("out: run: %v", err)
+	}
+	if buf.String() != "before hello alice\n" {
+		t.Fatalf("out: output: %q", buf.String())
+	}
+
+	r.SetOutput(nil)
+	stdout, _, err := r.TestRun(context.Background(), "bob")
+	if err != nil || stdout != "before hello bob\n" {
+		t.Fatalf("out: test run: %q, %v", stdout, err)
+	}
+
+	if w := Out(context.Background()); w != os.Stdout {
+		t.Fatalf("out: without run: %v", w)
+	}
+}
+
+func TestLastCommand(t *testing.T) {
+	var running []string
+	r := New("app", "")
+	r.Group("db", "", func() {
+		r.Use(func(ctx context.Context, next func(context.Context)) {
+			running = Command(ctx)
+			next(ctx)
+		})
+		r.HandleGroup("migrate", "", func() {})
+		r.HandleGroup("fail", "", func() error { return errors.New("failed") })
+	})
+
+	if r.LastCommand() != nil {
+		t.Fatalf("last command: before run: %q", r.LastCommand())
+	}
+	if _, err := r.Run(context.Background(), "db", "migrate"); err != nil {
+		t.Fatalf("last command: run: %v", err)
+	}
+	if got := strings.Join(r.LastCommand(), " "); got != "app db migrate" {
+		t.Fatalf("last command: %q", got)
+	}
+	if got := strings.Join(running, " "); got != "app db migrate" {
+		t.Fatalf("last command: running: %q", got)
+	}
+
+	r.Run(context.Background(), "db", "fail")
+	if got := strings.Join(r.LastCommand(), " "); got != "app db fail" {
+		t.Fatalf("last command: failed: %q", got)
+	}
+
+	for _, args := range [][]string{{"db", "migrate", "-h"}, {"db", "migrate", "--unknown"}} {
+		r.Run(context.Background(), args...)
+		if r.LastCommand() != nil {
+			t.Fatalf("last command: run %q: %q", args, r.LastCommand())
+		}
+	}
+	if Command(context.Background()) != nil {
+		t.Fatalf("last command: without run: %q", Command(context.Background()))
+	}
+}
+
+func TestLastCommandConcurrent(t *testing.T) {
+	r := New("app", "")
+	r.HandleGroup("a", "", func() {})
+	r.HandleGroup("b", "", func() {})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			r.Run(context.Background(), []string{"a", "b"}[i%2])
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if got := strings.Join(r.LastCommand(), " "); got != "app b" {
+				t.Fatalf("last command: concurrent: %q", got)
+			}
+			return
+		default:
+			if got := strings.Join(r.LastCommand(), " "); got != "" && got != "app a" && got != "app b" {
+				t.Fatalf("last command: concurrent: %q", got)
+			}
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	type resetOpts struct {
+		Name string   `long:"name" dft:"bob"`
+		Tags []string `long:"tag"`
+	}
+
+	var got []resetOpts
+	var level int
+	r := New("reset", "")
+	r.Var(&level, 'l', "level", "")
+	r.Handle(func(opt resetOpts) { got = append(got, opt) })
+	if _, err := r.Run(context.Background(), "--name", "alice", "--tag", "a", "-l", "3"); err != nil {
+		t.Fatalf("reset: run: %v", err)
+	}
+	r.Reset()
+	if v, set := r.Value("name"); v != nil || set || level != 0 {
+		t.Fatalf("reset: value after reset: %v, %v, level: %v", v, set, level)
+	}
+	if data, err := r.ConfigJSON(); err != nil || !strings.Contains(string(data), `"name": "bob"`) {
+		t.Fatalf("reset: config after reset: %s, %v", data, err)
+	}
+
+	if _, err := r.Run(context.Background(), "--tag", "b"); err != nil {
+		t.Fatalf("reset: run: %v", err)
+	}
+	want := []resetOpts{{"alice", []string{"a"}}, {"bob", []string{"b"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("reset: %+v", got)
+	}
+	if v, set := r.Value("name"); v != "bob" || set {
+		t.Fatalf("reset: value of second run: %v, %v", v, set)
+	}
+}
+
+func TestWithMiddlewares(t *testing.T) {
+	var trace []string
+	mw := func(name string) func(context.Context, func(context.Context)) {
+		return func(ctx context.Context, next func(context.Context)) {
+			trace = append(trace, name)
+			next(ctx)
+		}
+	}
+
+	r := New("with", "")
+	r.WithMiddlewares([]any{mw("auth"), mw("audit")}, func() {
+		r.HandleGroup("deploy", "", func() { trace = append(trace, "deploy") })
+		r.HandleGroup("rollback", "", func() { trace = append(trace, "rollback") })
+	})
+	r.HandleGroup("status", "", func() { trace = append(trace, "status") })
+
+	for _, c := range []struct {
+		cmd  string
+		want string
+	}{
+		{"deploy", "auth,audit,deploy"},
+		{"rollback", "auth,audit,rollback"},
+		{"status", "status"},
+	} {
+		trace = nil
+		if _, err := r.Run(context.Background(), c.cmd); err != nil {
+			t.Fatalf("with middlewares: run %v: %v", c.cmd, err)
+		}
+		if got := strings.Join(trace, ","); got != c.want {
+			t.Fatalf("with middlewares: run %v: %v", c.cmd, got)
+		}
+	}
+}
+
+func TestConcurrentRun(t *testing.T) {
+	type sumOpts struct {
+		Nums []int
+		Neg  bool `long:"neg"`
+	}
+
+	r := New("sum", "")
+	r.Handle(func(opt *sumOpts) (string, error) {
+		sum := 0
+		for _, n := range opt.Nums {
+			sum += n
+		}
+		if opt.Neg {
+			sum = -sum
+		}
+		return strconv.Itoa(sum), nil
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			args := []string{strconv.Itoa(i), "1"}
+			want := strconv.Itoa(i + 1)
+			if i%2 == 1 {
+				args = append(args, "--neg")
+				want = strconv.Itoa(-i - 1)
+			}
+			got, err := r.Run(context.Background(), args...)
+			if err == nil && got != want {
+				err = fmt.Errorf("run %q: got %v, want %v", args, got, want)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent run: %v", err)
+	}
+}
+
+func TestConcurrentHandlers(t *testing.T) {
+	type nameOpts struct {
+		Name string `long:"name"`
+	}
+
+	started, block := make(chan struct{}), make(chan struct{})
+	r := New("app", "")
+	r.HandleGroup("slow", "", func(opt *nameOpts) (string, error) {
+		close(started)
+		<-block
+		return opt.Name, nil
+	})
+	r.HandleGroup("echo", "", func(opt *nameOpts) (string, error) { return opt.Name, nil })
+	r.HandleGroup("nested", "", func(ctx context.Context, opt *nameOpts) (string, error) {
+		return r.Run(ctx, "echo", "--name", opt.Name+"!")
+	})
+
+	slow := make(chan string, 1)
+	go func() {
+		got, err := r.Run(context.Background(), "slow", "--name", "slow")
+		if err != nil {
+			got = err.Error()
+		}
+		slow <- got
+	}()
+	<-started
+
+	// the slow handler does not block other runs, nor reading their values
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			name := strconv.Itoa(i)
+			if got, err := r.Run(context.Background(), "nested", "--name", name); err != nil || got != name+"!" {
+				t.Errorf("concurrent handlers: nested %v: %v, %v", i, got, err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			r.Value("name")
+			r.DumpConfig(io.Discard)
+			r.ConfigJSON()
+			r.Reset()
+		}()
+	}
+	wg.Wait()
+
+	close(block)
+	if got := <-slow; got != "slow" {
+		t.Fatalf("concurrent handlers: slow: %v", got)
+	}
+}
+
+func TestTestRun(t *testing.T) {
+	type runOpts struct {
+		Name  string   `short:"n" long:"name" dft:"bob"`
+		Tags  []string `short:"t" long:"tag"`
+		Level int      `short:"l" long:"level"`
+	}
+
+	var got runOpts
+	r := New("app", "test run")
+	r.Handle(func(opt runOpts) { got = opt })
+
+	stdout, stderr, err := r.TestRun(context.Background(), "-n", "alice", "-t", "a", "-t", "b", "-l", "3")
+	if err != nil {
+		t.Fatalf("test run: %v", err)
+	}
+	if stdout != "" || stderr != "" {
+		t.Fatalf("test run: unexpected output: %q, %q", stdout, stderr)
+	}
+	want := runOpts{Name: "alice", Tags: []string{"a", "b"}, Level: 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("first run: %+v, want %+v", got, want)
+	}
+
+	_, _, err = r.TestRun(context.Background(), "-t", "c")
+	if err != nil {
+		t.Fatalf("test run: %v", err)
+	}
+	want = runOpts{Name: "bob", Tags: []string{"c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("second run: %+v, want %+v", got, want)
+	}
+
+	stdout, stderr, err = r.TestRun(context.Background(), "-h")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("test run help: %v", err)
+	}
+	if !strings.Contains(stdout, "--name") || stderr != "" {
+		t.Fatalf("test run help: stdout: %q, stderr: %q", stdout, stderr)
+	}
+
+	var out strings.Builder
+	r.SetOutput(&out)
+	stdout, stderr, err = r.TestRun(context.Background(), "-l", "x")
+	if err == nil {
+		t.Fatalf("test run invalid level: expect error")
+	}
+	wantErr := "app: parse option -l: strconv.ParseInt: parsing \"x\": invalid syntax\n"
+	if stdout != "" || stderr != wantErr {
+		t.Fatalf("test run invalid level: stdout: %q, stderr: %q", stdout, stderr)
+	}
+
+	r.runAndReport(context.Background(), []string{"-l", "x"})
+	if out.String() != wantErr {
+		t.Fatalf("test run: output not restored")
+	}
+}
+
+func TestHandleUnknown(t *testing.T) {
+	type verbose struct {
+		V bool `short:"v"`
+	}
+
+	var cmd, mw string
+	var args []string
+	var v bool
+	r := New("unknown", "")
+	r.Use(func(opt verbose) { v = opt.V; mw = "root" })
+	r.HandleGroup("known", "", func() { cmd = "known" })
+	r.HandleUnknown(func(ctx context.Context, c string, a []string) error {
+		cmd, args = c, a
+		if c == "fail" {
+			return errors.New("plugin failed")
+		}
+		return nil
+	})
+	r.Group("sub", "", func() {
+		r.Handle(func() { cmd = "sub" })
+	})
+
+	_, err := r.Run(context.Background(), "-v", "plugin", "-x", "--y=1", "z")
+	if err != nil {
+		t.Fatalf("unknown: run: %v", err)
+	}
+	if cmd != "plugin" || !reflect.DeepEqual(args, []string{"-x", "--y=1", "z"}) || !v || mw != "root" {
+		t.Fatalf("unknown: cmd: %q, args: %q, v: %v, mw: %q", cmd, args, v, mw)
+	}
+
+	if _, err = r.Run(context.Background(), "known"); err != nil || cmd != "known" {
+		t.Fatalf("unknown: run known: %v, cmd: %q", err, cmd)
+	}
+
+	_, err = r.Run(context.Background(), "fail")
+	if err == nil || err.Error() != "unknown: plugin failed" {
+		t.Fatalf("unknown: run fail: %v", err)
+	}
+
+	_, err = r.Run(context.Background())
+	if !errors.Is(err, ErrNoExecFunc) {
+		t.Fatalf("unknown: run without cmd: %v", err)
+	}
+
+	_, err = r.Run(context.Background(), "sub", "plugin")
+	if err == nil || !strings.Contains(err.Error(), "plugin") {
+		t.Fatalf("unknown: run sub with unknown cmd: %v", err)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	var got []string
+	r := New("shell", "")
+	r.HandleGroup("ls", "", func() { got = []string{"ls"} })
+	r.Fallback(func(ctx context.Context, args []string) error {
+		got = args
+		if args[0] == "fail" {
+			return errors.New("bad input")
+		}
+		return nil
+	})
+	r.Group("sub", "", func() {
+		r.Handle(func() { got = []string{"sub"} })
+		r.Fallback(func(args []string) { got = append([]string{"sub fallback"}, args...) })
+	})
+
+	for _, c := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"ls"}, []string{"ls"}},
+		{[]string{"echo", "-n", "hi"}, []string{"echo", "-n", "hi"}},
+		{[]string{"sub"}, []string{"sub"}},
+		{[]string{"sub", "cd", "/"}, []string{"sub fallback", "cd", "/"}},
+	} {
+		got = nil
+		if _, err := r.Run(context.Background(), c.args...); err != nil {
+			t.Fatalf("fallback: run %q: %v", c.args, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("fallback: run %q: got %q", c.args, got)
+		}
+	}
+
+	_, err := r.Run(context.Background(), "fail")
+	if err == nil || err.Error() != "shell: bad input" {
+		t.Fatalf("fallback: run fail: %v", err)
+	}
+
+	defer func() {
+		if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), "unsupported fallback handler") {
+			t.Fatalf("fallback: expect panic, got %v", e)
+		}
+	}()
+	r.Fallback(func(string) {})
+}
+
+func TestVar(t *testing.T) {
+	keys := []string{"host", "port"}
+	values := make(map[string]*string)
+
+	var level int
+	var ran bool
+	r := New("var", "")
+	for _, k := range keys {
+		values[k] = new(string)
+		r.Var(values[k], 0, k, "value of "+k)
+	}
+	r.Var(&level, 'l', "level", "log level", Default("0x10"), Tag("env", "VAR_TEST_LEVEL"))
+	r.Handle(func() { ran = true })
+
+	_, err := r.Run(context.Background(), "--host", "a", "-l", "3")
+	if err != nil || !ran {
+		t.Fatalf("var: run: %v", err)
+	}
+	if *values["host"] != "a" || *values["port"] != "" || level != 3 {
+		t.Fatalf("var: host: %q, port: %q, level: %v", *values["host"], *values["port"], level)
+	}
+	if v, set := r.Value("host"); v != "a" || !set {
+		t.Fatalf("var: value of host: %v, %v", v, set)
+	}
+	if v, set := r.Value("port"); v != "" || set {
+		t.Fatalf("var: value of port: %v, %v", v, set)
+	}
+
+	if _, err = r.Run(context.Background()); err != nil || level != 16 || *values["host"] != "" {
+		t.Fatalf("var: run with defaults: %v, level: %v, host: %q", err, level, *values["host"])
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, "--port string\n    value of port") ||
+		!strings.Contains(usage, "-l, --level int (default: 16)") {
+		t.Fatalf("var: usage:\n%v", usage)
+	}
+
+	r = New("var", "")
+	r.Var(new(string), 0, "name", "", Required())
+	r.Handle(func() {})
+	if _, err = r.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "--name") {
+		t.Fatalf("var: required: %v", err)
+	}
+
+	for _, c := range []struct {
+		ptr any
+		err string
+	}{
+		{level, "must be a non-nil pointer"},
+		{(*int)(nil), "must be a non-nil pointer"},
+		{new(int), "invalid unit tag"},
+	} {
+		func() {
+			defer func() {
+				if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), c.err) {
+					t.Fatalf("var: expect panic %q, got %v", c.err, e)
+				}
+			}()
+			New("var", "").Var(c.ptr, 0, "x", "", Tag("unit", "d"))
+		}()
+	}
+}
+
+func TestNameDesc(t *testing.T) {
+	r := New("app", "app desc")
+	if r.Name() != "app" || r.Desc() != "app desc" {
+		t.Fatalf("name desc: root: %q, %q", r.Name(), r.Desc())
+	}
+	r.Group("db", "db desc", func() {
+		r.Stmt(func() {
+			if r.Name() != "db" || r.Desc() != "db desc" {
+				t.Fatalf("name desc: db: %q, %q", r.Name(), r.Desc())
+			}
+		})
+	})
+	if r.Name() != "app" || r.Desc() != "app desc" {
+		t.Fatalf("name desc: root after group: %q, %q", r.Name(), r.Desc())
+	}
+}
+
+func TestFlagSet(t *testing.T) {
+	r := New("app", "")
+	root := r.FlagSet()
+	if root == nil || root != r.root.fs {
+		t.Fatalf("flagset: root: %v", root)
+	}
+	r.Group("sub", "", func() {
+		if fs := r.FlagSet(); fs == root || fs != r.cur.fs {
+			t.Fatalf("flagset: sub: %v", fs)
+		}
+	})
+	if r.FlagSet() != root {
+		t.Fatalf("flagset: root after group")
+	}
+}
+
+func TestComplex(t *testing.T) {
+	type complexes struct {
+		C  complex128   `short:"c" long:"complex" dft:"1+2i"`
+		C8 complex64    `long:"c64"`
+		Cs []complex128 `long:"cs" dft:"1i,2"`
+	}
+
+	var opt *complexes
+	r := New("complex", "")
+	r.Handle(func(o *complexes) { opt = o })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("complex: run: %v", err)
+	}
+	want := complexes{C: 1 + 2i, Cs: []complex128{1i, 2}}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("complex: default: %+v, want %+v", *opt, want)
+	}
+
+	_, err := r.Run(context.Background(), "-c", "3-4i", "--c64=(0.5+1i)", "--cs", "1,2i", "--cs=-3")
+	if err != nil {
+		t.Fatalf("complex: run: %v", err)
+	}
+	want = complexes{C: 3 - 4i, C8: 0.5 + 1i, Cs: []complex128{1, 2i, -3}}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("complex: %+v, want %+v", *opt, want)
+	}
+
+	_, err = r.Run(context.Background(), "-c", "3+")
+	if err == nil || !strings.Contains(err.Error(), "complex: parse option -c:") {
+		t.Fatalf("complex: parse 3+: %v", err)
+	}
+
+	_, err = r.Run(context.Background(), "--cs")
+	if !errors.Is(err, ErrNoInputValue) {
+		t.Fatalf("complex: no input value: %v", err)
+	}
+}
+
+func TestChar(t *testing.T) {
+	type chars struct {
+		Delim rune   `short:"d" long:"delimiter" dft:"\t" char:"true"`
+		Quote rune   `long:"quote" char:"true"`
+		Seps  []rune `long:"sep" char:"true" sep:";"`
+		Code  rune   `long:"code"`
+	}
+
+	var opt *chars
+	r := New("char", "")
+	r.Handle(func(o *chars) { opt = o })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("char: run: %v", err)
+	}
+	if opt.Delim != '\t' {
+		t.Fatalf("char: default delimiter: %q", opt.Delim)
+	}
+
+	_, err := r.Run(context.Background(), "--delimiter=,", "--quote", `\'`, "--sep", "中;:", "--code", "44")
+	if err != nil {
+		t.Fatalf("char: run: %v", err)
+	}
+	want := chars{Delim: ',', Quote: '\'', Seps: []rune{'中', ':'}, Code: 44}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("char: %+v, want %+v", *opt, want)
+	}
+
+	_, err = r.Run(context.Background(), "-d", "ab")
+	if err == nil || !strings.Contains(err.Error(), "must be exactly one character") {
+		t.Fatalf("char: multiple runes: %v", err)
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	for _, want := range []string{`-d, --delimiter char (default: '\t')`, "--quote char\n", "--sep []char", "--code int32"} {
+		if !strings.Contains(usage, want) {
+			t.Fatalf("char: usage: missing %q:\n%v", want, usage)
+		}
+	}
+	if got := formatChar(reflect.ValueOf([]rune{'a', '\n'})); got != `['a' '\n']` {
+		t.Fatalf("char: format slice: %v", got)
+	}
+}
+
+func TestSeparators(t *testing.T) {
+	type separated struct {
+		Strs []string         `long:"strs" sep:";" dft:"a,b;c"`
+		LM   []map[string]int `long:"lm" sep:",=|" dft:"a=1,b=2|c=3"`
+		ML   map[string][]int `long:"ml" sep:";:"`
+		M    map[string]int   `long:"m" sep:"/="`
+	}
+
+	var opt *separated
+	r := New("sep", "")
+	r.Handle(func(o *separated) { opt = o })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("separators: run: %v", err)
+	}
+	want := separated{
+		Strs: []string{"a,b", "c"},
+		LM:   []map[string]int{{"a": 1, "b": 2}, {"c": 3}},
+	}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("separators: default: %+v, want %+v", *opt, want)
+	}
+
+	_, err := r.Run(context.Background(),
+		"--strs", "x,y;z", "--strs=w",
+		"--lm=x=7|y=8,z=9", "--lm", "w=0",
+		"--ml", "a:1;a:2;b:3", "--ml=b:4",
+		"--m", "x=1/y=2",
+	)
+	if err != nil {
+		t.Fatalf("separators: run: %v", err)
+	}
+	want = separated{
+		Strs: []string{"x,y", "z", "w"},
+		LM:   []map[string]int{{"x": 7}, {"y": 8, "z": 9}, {"w": 0}},
+		ML:   map[string][]int{"a": {1, 2}, "b": {3, 4}},
+		M:    map[string]int{"x": 1, "y": 2},
+	}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("separators: %+v, want %+v", *opt, want)
+	}
+}
+
+func TestThirdSeparator(t *testing.T) {
+	type separated struct {
+		LM []map[string]int `long:"lm" sep:",:;"`
+		ML map[string][]int `long:"ml" sep:",:|"`
+	}
+
+	var got separated
+	newRouter := func() *Router {
+		r := New("sep3", "")
+		r.Handle(func(opt separated) { got = opt })
+		return r
+	}
+
+	r := newRouter()
+	_, err := r.Run(context.Background(), "--lm", "a:1,b:2;c:3", "--ml", "a:1|2,b:3", "--ml", "a:4")
+	if err != nil {
+		t.Fatalf("third separator: run: %v", err)
+	}
+	want := separated{
+		LM: []map[string]int{{"a": 1, "b": 2}, {"c": 3}},
+		ML: map[string][]int{"a": {1, 2, 4}, "b": {3}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("third separator: %+v, want %+v", got, want)
+	}
+
+	data, err := r.ConfigJSON()
+	if err != nil {
+		t.Fatalf("third separator: config json: %v", err)
+	}
+	r = newRouter()
+	if err = r.LoadConfig(writeConfig(t, string(data))); err != nil {
+		t.Fatalf("third separator: load config: %v", err)
+	}
+	got = separated{}
+	if _, err = r.Run(context.Background()); err != nil {
+		t.Fatalf("third separator: run with config: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("third separator: round trip: %+v, want %+v", got, want)
+	}
+
+	for _, opt := range []any{
+		func(*struct {
+			LM []map[string]int `sep:",:"`
+		}) {
+		},
+		func(*struct {
+			Servers []server `sep:",="`
+		}) {
+		},
+		func(*struct {
+			M map[string]int `sep:",:;"`
+		}) {
+		},
+		func(*struct {
+			N int `sep:","`
+		}) {
+		},
+	} {
+		func() {
+			defer func() {
+				if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), "sep tag") {
+					t.Fatalf("third separator: expect sep tag panic, got %v", e)
+				}
+			}()
+			New("sep3", "").Handle(opt)
+		}()
+	}
+}
+
+func TestTypedMapValues(t *testing.T) {
+	type typedMaps struct {
+		Timeouts map[string]time.Duration `long:"timeout" dft:"a:1s,b:2m"`
+		Starts   map[string]time.Time     `long:"start" dft:"a:2024-01-02T15:04:05"`
+		Ratios   map[string]float64       `long:"ratio" dft:"a:0.5"`
+	}
+
+	var got typedMaps
+	r := New("typed", "")
+	r.Handle(func(opt typedMaps) { got = opt })
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("typed map values: run: %v", err)
+	}
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)
+	want := typedMaps{
+		Timeouts: map[string]time.Duration{"a": time.Second, "b": 2 * time.Minute},
+		Starts:   map[string]time.Time{"a": start},
+		Ratios:   map[string]float64{"a": 0.5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("typed map values: default: %+v", got)
+	}
+
+	_, err := r.Run(context.Background(), "--timeout", "x:1h30m,y:250ms", "--start", "b:2025-03-04T05:06:07", "--ratio=b:1e-3")
+	if err != nil {
+		t.Fatalf("typed map values: run with args: %v", err)
+	}
+	want = typedMaps{
+		Timeouts: map[string]time.Duration{"x": 90 * time.Minute, "y": 250 * time.Millisecond},
+		Starts:   map[string]time.Time{"b": time.Date(2025, 3, 4, 5, 6, 7, 0, time.Local)},
+		Ratios:   map[string]float64{"b": 1e-3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("typed map values: args: %+v", got)
+	}
+}
+
+type codeError struct{ code int }
+
+func (e *codeError) Error() string { return fmt.Sprintf("code %v", e.code) }
+
+func TestCmdError(t *testing.T) {
+	r := New("app", "")
+	r.Group("db", "", func() {
+		r.HandleGroup("migrate", "", func() error {
+			return fmt.Errorf("migrate: %w", &codeError{code: 3})
+		})
+	})
+
+	_, err := r.Run(context.Background(), "db", "migrate")
+	var cmdErr *CmdError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("cmd error: not a *CmdError: %v", err)
+	}
+	if !reflect.DeepEqual(cmdErr.Path(), []string{"app", "db", "migrate"}) {
+		t.Fatalf("cmd error: path: %q", cmdErr.Path())
+	}
+	if err.Error() != "app db migrate: migrate: code 3" {
+		t.Fatalf("cmd error: %v", err)
+	}
+	var codeErr *codeError
+	if !errors.As(err, &codeErr) || codeErr.code != 3 {
+		t.Fatalf("cmd error: cannot reach original error: %v", err)
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	type deprecatedOpts struct {
+		Old string `short:"o" long:"old" deprecated:"use --new instead"`
+		New string `long:"new"`
+	}
+
+	r := New("app", "")
+	r.Group("legacy", "", func() {
+		r.DeprecateCommand("use app modern instead")
+		r.Handle(func(*deprecatedOpts) {})
+	})
+	r.Handle(func(*deprecatedOpts) {})
+
+	for _, c := range []struct {
+		args   []string
+		stderr string
+	}{
+		{[]string{"--new", "x"}, ""},
+		{[]string{"-o", "x", "--old", "y"}, "flagrouter: warning: option --old is deprecated: use --new instead\n"},
+		{[]string{"legacy"}, "flagrouter: warning: command app legacy is deprecated: use app modern instead\n"},
+	} {
+		_, stderr, err := r.TestRun(context.Background(), c.args...)
+		if err != nil {
+			t.Fatalf("deprecated %q: %v", c.args, err)
+		}
+		if stderr != c.stderr {
+			t.Fatalf("deprecated %q: stderr: %q, want %q", c.args, stderr, c.stderr)
+		}
+	}
+}
+
+func TestMultiCharSeparators(t *testing.T) {
+	type separated struct {
+		List []string          `long:"list" seps:"||" dft:"a,b||c"`
+		Map  map[string]int    `long:"map" seps:";; =>" dft:"a=>1;;b=>2"`
+		LM   []map[string]bool `long:"lm" seps:"&& :: ||"`
+	}
+
+	var opt *separated
+	r := New("seps", "")
+	r.Handle(func(o *separated) { opt = o })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("multi-char separators: run: %v", err)
+	}
+	want := separated{List: []string{"a,b", "c"}, Map: map[string]int{"a": 1, "b": 2}}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("multi-char separators: default: %+v, want %+v", *opt, want)
+	}
+
+	_, err := r.Run(context.Background(), "--list", "x||y", "--map=c=>3;;d=>4", "--lm", "a::true&&b::false||c::true")
+	if err != nil {
+		t.Fatalf("multi-char separators: run: %v", err)
+	}
+	want = separated{
+		List: []string{"x", "y"},
+		Map:  map[string]int{"c": 3, "d": 4},
+		LM:   []map[string]bool{{"a": true, "b": false}, {"c": true}},
+	}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("multi-char separators: %+v, want %+v", *opt, want)
+	}
+}
+
+// hostPort implements flag.Value only.
+type hostPort struct {
+	Host string
+	Port int
+}
+
+func (hp *hostPort) String() string { return fmt.Sprintf("%v:%v", hp.Host, hp.Port) }
+
+func (hp *hostPort) Set(s string) error {
+	host, port, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("missing port in %q", s)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return err
+	}
+	*hp = hostPort{Host: host, Port: n}
+	return nil
+}
+
+// appendList implements flag.Value, Set appends like flag.Var in std library.
+type appendList []string
+
+func (l *appendList) String() string { return strings.Join(*l, ",") }
+
+func (l *appendList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func TestFlagValue(t *testing.T) {
+	type flagValues struct {
+		Addr  hostPort   `long:"addr" dft:"localhost:80"`
+		Peers []hostPort `long:"peer"`
+		List  appendList `long:"list"`
+	}
+
+	var opt *flagValues
+	r := New("flag_value", "")
+	r.Handle(func(o *flagValues) { opt = o })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("flag value: run: %v", err)
+	}
+	if opt.Addr != (hostPort{Host: "localhost", Port: 80}) {
+		t.Fatalf("flag value: default: %+v", opt.Addr)
+	}
+
+	_, err := r.Run(context.Background(), "--addr", "example.com:443", "--peer", "a:1,b:2", "--list", "x,y", "--list=z")
+	if err != nil {
+		t.Fatalf("flag value: run: %v", err)
+	}
+	want := flagValues{
+		Addr:  hostPort{Host: "example.com", Port: 443},
+		Peers: []hostPort{{Host: "a", Port: 1}, {Host: "b", Port: 2}},
+		List:  appendList{"x,y", "z"},
+	}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("flag value: %+v, want %+v", *opt, want)
+	}
+
+	_, err = r.Run(context.Background(), "--addr", "example.com")
+	if err == nil || !strings.Contains(err.Error(), `parse option --addr: missing port in "example.com"`) {
+		t.Fatalf("flag value: invalid: %v", err)
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, "--addr flagrouter.hostPort (default: localhost:80)") {
+		t.Fatalf("flag value: usage:\n%v", usage)
+	}
+}
+
+// switchValue implements flag.Value with IsBoolFlag, like bool flags of std library.
+type switchValue string
+
+func (s *switchValue) String() string { return string(*s) }
+
+func (s *switchValue) Set(v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*s = switchValue(map[bool]string{true: "on", false: "off"}[b])
+	return nil
+}
+
+func (s *switchValue) IsBoolFlag() bool { return true }
+
+func TestBoolFlagValue(t *testing.T) {
+	type switches struct {
+		Debug switchValue `short:"d" long:"debug" dft:"false"`
+		Trace switchValue `long:"trace" dft:"false"`
+		Files []string
+	}
+
+	var got switches
+	r := New("bool_flag", "")
+	r.Handle(func(opt switches) { got = opt })
+
+	if _, err := r.Run(context.Background(), "-d", "a", "--trace=false", "b"); err != nil {
+		t.Fatalf("bool flag value: run: %v", err)
+	}
+	want := switches{Debug: "on", Trace: "off", Files: []string{"a", "b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bool flag value: %+v", got)
+	}
+
+	if _, err := r.Run(context.Background(), "--trace=maybe"); err == nil {
+		t.Fatalf("bool flag value: invalid: expect error")
+	}
+}
+
+func TestJSONTag(t *testing.T) {
+	type server struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	type jsonValues struct {
+		Labels map[string]string `long:"labels" json:"true" dft:"{\"env\":\"dev\"}"`
+		Server server            `long:"server" encoding:"json"`
+		Name   string            `long:"name" json:"name"`
+	}
+
+	var opt *jsonValues
+	r := New("json", "")
+	r.Handle(func(o *jsonValues) { opt = o })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("json: run: %v", err)
+	}
+	if !reflect.DeepEqual(opt.Labels, map[string]string{"env": "dev"}) {
+		t.Fatalf("json: default labels: %v", opt.Labels)
+	}
+
+	_, err := r.Run(context.Background(), "--labels", `{"a":"b","c":"d"}`,
+		"--server", `{"host":"example.com","port":443}`, "--name", "plain")
+	if err != nil {
+		t.Fatalf("json: run: %v", err)
+	}
+	want := jsonValues{
+		Labels: map[string]string{"a": "b", "c": "d"},
+		Server: server{Host: "example.com", Port: 443},
+		Name:   "plain",
+	}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("json: %+v, want %+v", *opt, want)
+	}
+
+	_, err = r.Run(context.Background(), "--server", `{"host":`)
+	if err == nil || !strings.Contains(err.Error(), "json: parse option --server: unexpected end of JSON input") {
+		t.Fatalf("json: malformed: %v", err)
+	}
+}
+
+func TestGroupAlias(t *testing.T) {
+	r := New("alias", "")
+	var removed []string
+	r.GroupAlias("remove", "remove files", []string{"rm", "del"}, func() {
+		r.Handle(func(opt *struct {
+			Files []string
+		}) {
+			removed = append(removed, opt.Files...)
+		})
+	})
+
+	for _, cmd := range []string{"remove", "rm", "del"} {
+		if _, err := r.Run(context.Background(), cmd, cmd+".txt"); err != nil {
+			t.Fatalf("alias run %v: %v", cmd, err)
+		}
+	}
+	if want := []string{"remove.txt", "rm.txt", "del.txt"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("alias: removed: %v", removed)
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, "  remove (aliases: rm, del)\n    remove files") {
+		t.Fatalf("alias: usage:\n%v", usage)
+	}
+	usage, _ = r.Run(context.Background(), "rm", "-h")
+	if !strings.HasPrefix(usage, "alias remove - remove files\n") {
+		t.Fatalf("alias: usage of rm:\n%v", usage)
+	}
+
+	for name, register := range map[string]func(){
+		"alias conflicts name":  func() { r.GroupAlias("delete", "", []string{"remove"}, func() {}) },
+		"alias conflicts alias": func() { r.GroupAlias("delete", "", []string{"rm"}, func() {}) },
+		"name conflicts alias":  func() { r.Group("del", "", func() {}) },
+		"alias same as name":    func() { r.GroupAlias("list", "", []string{"list"}, func() {}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("alias: %v: expect registration error", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+type server struct {
+	Host   string `long:"host" transform:"lower"`
+	Port   int    `dft:"80" max:"65535"`
+	Secure bool
+}
+
+func TestStructSlice(t *testing.T) {
+	r := New("struct_slice", "")
+	var got []server
+	var primary server
+	r.Handle(func(opt *struct {
+		Servers []server `long:"server" dft:"host=localhost;host=backup,port=8080"`
+		Primary server   `long:"primary" sep:"/:"`
+	}) {
+		got, primary = opt.Servers, opt.Primary
+	})
+
+	ctx := context.Background()
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("struct slice: run: %v", err)
+	}
+	want := []server{{Host: "localhost", Port: 80}, {Host: "backup", Port: 8080}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("struct slice: default: %+v", got)
+	}
+
+	_, err := r.Run(ctx, "--server", "host=A,port=1", "--server", "host=b, port=2, secure=true",
+		"--primary", "host:main/secure:true")
+	if err != nil {
+		t.Fatalf("struct slice: run: %v", err)
+	}
+	want = []server{{Host: "a", Port: 1}, {Host: "b", Port: 2, Secure: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("struct slice: flags: %+v", got)
+	}
+	if want := (server{Host: "main", Port: 80, Secure: true}); primary != want {
+		t.Fatalf("struct slice: primary: %+v", primary)
+	}
+
+	for _, arg := range []string{"host", "name=a", "port=x", "port=70000"} {
+		if _, err := r.Run(ctx, "--server", arg); err == nil {
+			t.Fatalf("struct slice: expect error for %q", arg)
+		}
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	r := New("ignore", "")
+	var got string
+	r.Handle(func(opt *struct {
+		Name  string `long:"name"`
+		Notes string `short:"n" long:"notes" desc:"used by others" ignore:"true"`
+		Extra string `ignore:"true"`
+		Files []string
+	}) {
+		got = opt.Name + ":" + strings.Join(opt.Files, ",")
+	})
+
+	if _, err := r.Run(context.Background(), "--name", "x", "a", "b"); err != nil {
+		t.Fatalf("ignore: run: %v", err)
+	}
+	if got != "x:a,b" {
+		t.Fatalf("ignore: got %q", got)
+	}
+	if _, err := r.Run(context.Background(), "--notes", "x"); err == nil {
+		t.Fatalf("ignore: ignored field registered as a flag")
+	}
+	usage, _ := r.Run(context.Background(), "-h")
+	if strings.Contains(usage, "notes") || strings.Contains(usage, "EXTRA") {
+		t.Fatalf("ignore: usage:\n%v", usage)
+	}
+}
+
+func TestAllowPrefixMatch(t *testing.T) {
+	r := New("git", "")
+	var got string
+	for _, name := range []string{"checkout", "commit", "co", "status"} {
+		name := name
+		r.HandleGroup(name, "", func() { got = name })
+	}
+	r.GroupAlias("remote", "", []string{"rem"}, func() {
+		r.HandleGroup("add", "", func() { got = "remote add" })
+	})
+
+	if _, err := r.Run(context.Background(), "stat"); err == nil {
+		t.Fatalf("prefix match: matched when disabled")
+	}
+
+	r.AllowPrefixMatch(true)
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"co"}, "co"},
+		{[]string{"che"}, "checkout"},
+		{[]string{"s"}, "status"},
+		{[]string{"re", "a"}, "remote add"},
+	} {
+		got = ""
+		if _, err := r.Run(context.Background(), c.args...); err != nil {
+			t.Fatalf("prefix match %q: %v", c.args, err)
+		}
+		if got != c.want {
+			t.Fatalf("prefix match %q: got %q, want %q", c.args, got, c.want)
+		}
+	}
+
+	_, err := r.Run(context.Background(), "c")
+	if err == nil || !strings.Contains(err.Error(), `ambiguous command "c", could be: checkout, commit, co`) {
+		t.Fatalf("prefix match: ambiguous: %v", err)
+	}
+}
+
+func TestBytesEncoding(t *testing.T) {
+	type key []byte
+	type blobs struct {
+		Hex    []byte `long:"hex" encoding:"hex" dft:"deadbeef"`
+		Base64 key    `long:"b64" encoding:"base64" dft:"aGVsbG8="`
+		Raw    []byte `long:"raw" dft:"1,2"`
+		Token  []byte `long:"token" base64:"true"`
+		Split  []byte `long:"split" sep:"," dft:"1,2"`
+	}
+
+	var got blobs
+	r := New("bytes", "")
+	r.Handle(func(opt blobs) { got = opt })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("bytes encoding: run: %v", err)
+	}
+	want := blobs{Hex: []byte{0xde, 0xad, 0xbe, 0xef}, Base64: key("hello"), Raw: []byte("1,2"), Split: []byte{1, 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bytes encoding: defaults: %+v", got)
+	}
+
+	_, err := r.Run(context.Background(), "--hex", "CAFE", "--b64", "_-8", "--raw", "a b,c", "--token", "aGk", "--split", "3")
+	if err != nil {
+		t.Fatalf("bytes encoding: run: %v", err)
+	}
+	want = blobs{Hex: []byte{0xca, 0xfe}, Base64: key{0xff, 0xef}, Raw: []byte("a b,c"), Token: []byte("hi"), Split: []byte{3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bytes encoding: %+v", got)
+	}
+
+	for _, args := range [][]string{{"--hex", "xyz"}, {"--hex", "abc"}, {"--b64", "a$b"}, {"--token", "a$b"}} {
+		if _, err := r.Run(context.Background(), args...); err == nil {
+			t.Fatalf("bytes encoding: run %q: no error", args)
+		}
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, "--hex hex (default: deadbeef)") || !strings.Contains(usage, "--b64 base64 (default: aGVsbG8=)") ||
+		!strings.Contains(usage, "--raw string (default: 1,2)") || !strings.Contains(usage, "--token base64") {
+		t.Fatalf("bytes encoding: usage:\n%v", usage)
+	}
+
+	for _, c := range []struct {
+		handler any
+		err     string
+	}{
+		{func(*struct {
+			S string `long:"s" encoding:"hex"`
+		}) {
+		}, "encoding tag hex: unsupported type"},
+		{func(*struct {
+			B []byte `long:"b" encoding:"base32"`
+		}) {
+		}, "invalid encoding tag"},
+		{func(*struct {
+			B []byte `long:"b" encoding:"hex" dft:"0"`
+		}) {
+		}, "dft tag"},
+		{func(*struct {
+			B []byte `long:"b" encoding:"hex" base64:"true"`
+		}) {
+		}, "base64 tag conflicts"},
+	} {
+		func() {
+			defer func() {
+				if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), c.err) {
+					t.Fatalf("bytes encoding: expect panic %q, got %v", c.err, e)
+				}
+			}()
+			New("bytes", "").Handle(c.handler)
+		}()
+	}
+}
+
+func TestSliceElementError(t *testing.T) {
+	r := New("elems", "")
+	r.Handle(func(*struct {
+		Peers []hostPort `long:"peer" dft:"a:1"`
+	}) {
+	})
+	_, err := r.Run(context.Background(), "--peer", "a:1,b")
+	if err == nil || !strings.Contains(err.Error(), `element 1 "b": missing port in "b"`) {
+		t.Fatalf("slice element error: run: %v", err)
+	}
+
+	defer func() {
+		if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), `element 1 "x:y"`) {
+			t.Fatalf("slice element error: expect panic, got %v", e)
+		}
+	}()
+	New("elems", "").Handle(func(*struct {
+		Peers []hostPort `long:"peer" dft:"a:1,x:y"`
+	}) {
+	})
+}
+
+func TestRegexp(t *testing.T) {
+	type grep struct {
+		Pattern *regexp.Regexp   `short:"e" long:"regexp" dft:"^err"`
+		Exclude []*regexp.Regexp `long:"exclude"`
+	}
+
+	var got grep
+	r := New("grep", "")
+	r.Handle(func(opt grep) { got = opt })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("regexp: run: %v", err)
+	}
+	if got.Pattern.String() != "^err" || got.Exclude != nil {
+		t.Fatalf("regexp: defaults: %+v", got)
+	}
+
+	if _, err := r.Run(context.Background(), "-e", `\d+`, "--exclude", "a,b", "--exclude", "c"); err != nil {
+		t.Fatalf("regexp: run: %v", err)
+	}
+	if !got.Pattern.MatchString("x42") || got.Pattern.MatchString("err") || len(got.Exclude) != 3 || got.Exclude[2].String() != "c" {
+		t.Fatalf("regexp: %+v", got)
+	}
+
+	if _, err := r.Run(context.Background(), "-e", "a("); err == nil || !strings.Contains(err.Error(), "regexp") {
+		t.Fatalf("regexp: invalid pattern: %v", err)
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, `-e, --regexp regexp (default: "^err")`) {
+		t.Fatalf("regexp: usage:\n%v", usage)
+	}
+	r.Reset()
+	data, err := r.ConfigJSON()
+	if err != nil || !strings.Contains(string(data), `"regexp": "^err"`) {
+		t.Fatalf("regexp: config json: %s, %v", data, err)
+	}
+
+	defer func() {
+		if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), "field Pattern") {
+			t.Fatalf("regexp: expect panic, got %v", e)
+		}
+	}()
+	New("grep", "").Handle(func(*struct {
+		Pattern *regexp.Regexp `long:"p" dft:"a("`
+	}) {
+	})
+}
+
+func TestHardwareAddr(t *testing.T) {
+	type inventory struct {
+		MAC   net.HardwareAddr   `long:"mac" dft:"00:00:5e:00:53:01"`
+		Peers []net.HardwareAddr `long:"peer"`
+	}
+
+	var got inventory
+	r := New("inventory", "")
+	r.Handle(func(opt inventory) { got = opt })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("hardware addr: run: %v", err)
+	}
+	if got.MAC.String() != "00:00:5e:00:53:01" || got.Peers != nil {
+		t.Fatalf("hardware addr: defaults: %+v", got)
+	}
+
+	_, err := r.Run(context.Background(), "--mac", "02-00-5E-10-00-00", "--peer", "00:00:5e:00:53:02,00:00:5e:00:53:03")
+	if err != nil {
+		t.Fatalf("hardware addr: run: %v", err)
+	}
+	if got.MAC.String() != "02:00:5e:10:00:00" || len(got.Peers) != 2 || got.Peers[1].String() != "00:00:5e:00:53:03" {
+		t.Fatalf("hardware addr: %+v", got)
+	}
+
+	if _, err := r.Run(context.Background(), "--mac", "zz:00"); err == nil || !strings.Contains(err.Error(), "invalid MAC address") {
+		t.Fatalf("hardware addr: invalid: %v", err)
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, "--mac mac (default: 00:00:5e:00:53:01)") || !strings.Contains(usage, "--peer []net.HardwareAddr") {
+		t.Fatalf("hardware addr: usage:\n%v", usage)
+	}
+	r.Reset()
+	data, err := r.ConfigJSON()
+	if err != nil || !strings.Contains(string(data), `"mac": "00:00:5e:00:53:01"`) {
+		t.Fatalf("hardware addr: config json: %s, %v", data, err)
+	}
+
+	defer func() {
+		if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), "field MAC") {
+			t.Fatalf("hardware addr: expect panic, got %v", e)
+		}
+	}()
+	New("inventory", "").Handle(func(*struct {
+		MAC net.HardwareAddr `long:"mac" dft:"00:00"`
+	}) {
+	})
+}
+
+func TestEmail(t *testing.T) {
+	type notify struct {
+		From mail.Address    `long:"from" dft:"Alert <alert@example.com>"`
+		To   []*mail.Address `long:"to"`
+		Cc   *mail.Address   `long:"cc"`
+	}
+
+	var got notify
+	r := New("notify", "")
+	r.Handle(func(opt notify) { got = opt })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("email: run: %v", err)
+	}
+	if got.From != (mail.Address{Name: "Alert", Address: "alert@example.com"}) || got.To != nil || got.Cc != nil {
+		t.Fatalf("email: defaults: %+v", got)
+	}
+
+	_, err := r.Run(context.Background(), "--to", `"Doe, John" <john@example.com>, jane@example.com`, "--to", "ops@example.com", "--cc", "cc@example.com")
+	if err != nil {
+		t.Fatalf("email: run: %v", err)
+	}
+	if len(got.To) != 3 || got.To[0].Name != "Doe, John" || got.To[1].Address != "jane@example.com" ||
+		got.To[2].Address != "ops@example.com" || got.Cc.Address != "cc@example.com" {
+		t.Fatalf("email: %+v", got)
+	}
+
+	for _, args := range [][]string{{"--cc", "nobody"}, {"--to", "a@example.com, <b"}} {
+		if _, err := r.Run(context.Background(), args...); err == nil || !strings.Contains(err.Error(), "invalid email address") {
+			t.Fatalf("email: run %q: %v", args, err)
+		}
+	}
+
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, `--from email (default: "Alert" <alert@example.com>)`) || !strings.Contains(usage, "--cc email") {
+		t.Fatalf("email: usage:\n%v", usage)
+	}
+	r.Reset()
+	data, err := r.ConfigJSON()
+	if err != nil || !strings.Contains(string(data), `"from": "\"Alert\" \u003calert@example.com\u003e"`) {
+		t.Fatalf("email: config json: %s, %v", data, err)
+	}
+
+	defer func() {
+		if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), "field From") {
+			t.Fatalf("email: expect panic, got %v", e)
+		}
+	}()
+	New("notify", "").Handle(func(*struct {
+		From *mail.Address `long:"from" dft:"not an address"`
+	}) {
+	})
+}
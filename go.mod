@@ -2,4 +2,8 @@ module github.com/eachain/flagrouter
 
 go 1.21.13
 
-require github.com/eachain/flags v1.0.0
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/eachain/flags v1.0.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,79 @@
+package flagrouter
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/eachain/flags"
+)
+
+// LoggingMiddleware returns a middleware logs every run with logger,
+// including command path, flag values, start/end time and error.
+// Values of flags tagged `secret:"true"` are redacted.
+func LoggingMiddleware(logger *slog.Logger) any {
+	return flags.Middleware(func(ctx context.Context, handler flags.Handler) {
+		start := time.Now()
+		handler(ctx)
+		end := time.Now()
+
+		st := getState(ctx)
+		attrs := []slog.Attr{
+			slog.String("cmd", st.cmdPath()),
+			slog.Group("flags", st.flagAttrs()...),
+			slog.Time("start", start),
+			slog.Time("end", end),
+		}
+		level := slog.LevelInfo
+		if st.err != nil {
+			level = slog.LevelError
+			attrs = append(attrs, slog.Any("error", st.err))
+		}
+		logger.LogAttrs(ctx, level, "run command", attrs...)
+	})
+}
+
+// cmdPath returns full name of the command to exec, like `app db migrate`.
+func (st *state) cmdPath() string {
+	return strings.Join(st.cmdNames(), " ")
+}
+
+func (st *state) cmdNames() []string {
+	names := make([]string, 0, len(st.path))
+	for _, n := range st.path {
+		if n.name != "" {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+func (st *state) flagAttrs() []any {
+	attrs := make([]any, 0, len(st.node.params))
+	for _, p := range st.node.params {
+		name := p.long
+		if name == flags.NoLong {
+			if p.short == flags.NoShort {
+				continue
+			}
+			name = string(p.short)
+		}
+		if p.secret {
+			attrs = append(attrs, slog.String(name, "******"))
+			continue
+		}
+		attrs = append(attrs, slog.Any(name, st.value(p.ptr).Interface()))
+	}
+	return attrs
+}
+
+// TimeoutMiddleware returns a middleware passes a context canceled after d
+// to the handler. Handlers ignore the context are not affected.
+func TimeoutMiddleware(d time.Duration) any {
+	return flags.Middleware(func(ctx context.Context, handler flags.Handler) {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		handler(ctx)
+	})
+}
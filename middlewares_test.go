@@ -0,0 +1,78 @@
+package flagrouter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type loggingOptions struct {
+	User     string `short:"u" long:"user"`
+	Password string `long:"password" secret:"true"`
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	buf := new(bytes.Buffer)
+	errFailed := errors.New("failed")
+
+	r := New("app", "")
+	r.Use(LoggingMiddleware(slog.New(slog.NewJSONHandler(buf, nil))))
+	r.Group("db", "", func() {
+		r.HandleGroup("migrate", "", func(opt *loggingOptions) {})
+		r.HandleGroup("fail", "", func() error { return errFailed })
+	})
+
+	var record struct {
+		Level string
+		Msg   string
+		Cmd   string
+		Flags map[string]any
+		Error string
+	}
+
+	_, err := r.Run(context.Background(), "db", "migrate", "-u", "root", "--password", "123456")
+	if err != nil {
+		t.Fatalf("logging run: %v", err)
+	}
+	if err = json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("logging: decode record: %v", err)
+	}
+	if record.Level != "INFO" || record.Cmd != "app db migrate" || record.Error != "" ||
+		record.Flags["user"] != "root" || record.Flags["password"] != "******" {
+		t.Fatalf("logging: record: %+v", record)
+	}
+
+	buf.Reset()
+	_, err = r.Run(context.Background(), "db", "fail")
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("logging run fail: %v", err)
+	}
+	if err = json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("logging: decode record: %v", err)
+	}
+	if record.Level != "ERROR" || record.Cmd != "app db fail" || record.Error != "app db fail: failed" {
+		t.Fatalf("logging: fail record: %+v", record)
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	r := New("timeout", "")
+	r.Use(TimeoutMiddleware(10 * time.Millisecond))
+	r.Handle(func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+
+	_, err := r.Run(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("timeout run: %v", err)
+	}
+}
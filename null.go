@@ -0,0 +1,51 @@
+package flagrouter
+
+import (
+	"reflect"
+)
+
+// nullField returns index of the value field of typ, if typ is a nullable
+// struct like sql.NullString and sql.Null[T]: it has exactly two fields,
+// `Valid bool` and an exported field of a scalar type.
+func nullField(typ reflect.Type) (int, bool) {
+	if typ.Kind() != reflect.Struct || typ.NumField() != 2 || isFlagValue(typ) {
+		return 0, false
+	}
+	valid, ok := typ.FieldByName("Valid")
+	if !ok || len(valid.Index) != 1 || valid.Type.Kind() != reflect.Bool {
+		return 0, false
+	}
+	i := 1 - valid.Index[0]
+	field := typ.Field(i)
+	switch field.Type.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Pointer, reflect.Interface:
+		return 0, false
+	}
+	if !field.IsExported() || isKVStruct(field.Type) || !routerParses(field.Type) {
+		return 0, false
+	}
+	return i, true
+}
+
+// parseNull parses s to the value field i of nullable typ, and sets Valid.
+func parseNull(typ reflect.Type, i int, s string, opts parseOpts) (any, error) {
+	ft := typ.Field(i).Type
+	x, err := parseValue(ft, s, opts)
+	if err != nil {
+		return nil, err
+	}
+	val := reflect.New(typ).Elem()
+	val.Field(i).Set(reflect.ValueOf(x).Convert(ft))
+	val.FieldByName("Valid").SetBool(true)
+	return val.Interface(), nil
+}
+
+// nullValue returns the value field of nullable val,
+// and whether it is valid. ok is false if val is not nullable.
+func nullValue(val reflect.Value) (v reflect.Value, valid, ok bool) {
+	i, ok := nullField(val.Type())
+	if !ok {
+		return reflect.Value{}, false, false
+	}
+	return val.Field(i), val.FieldByName("Valid").Bool(), true
+}
@@ -0,0 +1,92 @@
+package flagrouter
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nullPort and nullDuration are nullable shapes not from database/sql.
+type nullPort struct {
+	Port  uint16
+	Valid bool
+}
+
+type nullDuration struct {
+	Valid bool
+	D     time.Duration
+}
+
+func TestNull(t *testing.T) {
+	type filters struct {
+		Name   sql.NullString  `long:"name"`
+		Age    sql.NullInt64   `long:"age"`
+		Active sql.NullBool    `long:"active"`
+		Since  sql.NullTime    `long:"since"`
+		Score  sql.NullFloat64 `long:"score" dft:"0.5"`
+		Port   nullPort        `long:"port"`
+		Level  sql.NullInt16   `long:"level"`
+		Limit  nullDuration    `long:"limit"`
+	}
+
+	var got filters
+	r := New("null", "")
+	r.Handle(func(opt filters) { got = opt })
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("null: run: %v", err)
+	}
+	want := filters{Score: sql.NullFloat64{Float64: 0.5, Valid: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("null: defaults: %+v", got)
+	}
+
+	_, err := r.Run(context.Background(), "--name", "", "--age=0x10", "--active", "no",
+		"--since", "2024-01-02T15:04:05", "--port", "8080", "--limit", "1m")
+	if err != nil {
+		t.Fatalf("null: run with args: %v", err)
+	}
+	want = filters{
+		Name:   sql.NullString{Valid: true},
+		Age:    sql.NullInt64{Int64: 16, Valid: true},
+		Active: sql.NullBool{Valid: true},
+		Since:  sql.NullTime{Time: time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local), Valid: true},
+		Score:  sql.NullFloat64{Float64: 0.5, Valid: true},
+		Port:   nullPort{Port: 8080, Valid: true},
+		Limit:  nullDuration{D: time.Minute, Valid: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("null: %+v", got)
+	}
+
+	data, err := r.ConfigJSON()
+	if err != nil {
+		t.Fatalf("null: config json: %v", err)
+	}
+	if !strings.Contains(string(data), `"level": null`) || !strings.Contains(string(data), `"age": 16`) {
+		t.Fatalf("null: config json:\n%s", data)
+	}
+	first := got
+	r = New("null", "")
+	r.Handle(func(opt filters) { got = opt })
+	if err = r.LoadConfig(writeConfig(t, string(data))); err != nil {
+		t.Fatalf("null: load config: %v", err)
+	}
+	if _, err = r.Run(context.Background()); err != nil {
+		t.Fatalf("null: run with config: %v", err)
+	}
+	if !reflect.DeepEqual(got, first) {
+		t.Fatalf("null: round trip: %+v", got)
+	}
+
+	if _, err = r.Run(context.Background(), "--port", "70000"); err == nil {
+		t.Fatalf("null: overflow: expect error")
+	}
+	usage, _ := r.Run(context.Background(), "-h")
+	if !strings.Contains(usage, "--age int64\n") || !strings.Contains(usage, "--score float64 (default: 0.5)") {
+		t.Fatalf("null: usage:\n%v", usage)
+	}
+}
@@ -0,0 +1,99 @@
+package flagrouter
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+)
+
+// SetOutput sets the destination of help text, warnings and error messages.
+// If w is nil, which is the default, help text is written to os.Stdout,
+// and others are written to os.Stderr.
+func (r *Router) SetOutput(w io.Writer) {
+	r.outw = w
+	r.errw = w
+}
+
+func (r *Router) stdout() io.Writer {
+	if r.outw != nil {
+		return r.outw
+	}
+	return os.Stdout
+}
+
+// Out returns the output of the run of ctx, set by SetOutput, for handlers
+// and middlewares to write output to, instead of os.Stdout directly, so that
+// output can be captured, such as by tests. It returns os.Stdout if ctx is
+// not passed by Run.
+func Out(ctx context.Context) io.Writer {
+	if st := getState(ctx); st != nil && st.stdout != nil {
+		return st.stdout
+	}
+	return os.Stdout
+}
+
+func (r *Router) stderr() io.Writer {
+	if r.errw != nil {
+		return r.errw
+	}
+	return os.Stderr
+}
+
+// SetInput sets the source of values of flags tagged `fromstdin:"true"`,
+// os.Stdin is used if not set.
+func (r *Router) SetInput(rd io.Reader) {
+	r.in = rd
+}
+
+func (r *Router) stdin() io.Reader {
+	if r.in != nil {
+		return r.in
+	}
+	return os.Stdin
+}
+
+// TestRun runs args like RunCmdlineE, with output redirected during the run,
+// and returns help text and what handlers wrote to Out as stdout, warnings
+// and error messages as stderr.
+// It is a helper for testing commands, and not safe for concurrent use.
+func (r *Router) TestRun(ctx context.Context, args ...string) (stdout, stderr string, err error) {
+	outw, errw := r.outw, r.errw
+	defer func() { r.outw, r.errw = outw, errw }()
+
+	var outb, errb strings.Builder
+	r.outw, r.errw = &outb, &errb
+	_, err = r.runAndReport(ctx, args)
+	return outb.String(), errb.String(), err
+}
+
+// SetTranslator sets fn to translate user-facing strings rendered by router,
+// such as help, warnings and errors, for localization. msg is the default
+// English text, and key identifies it:
+//   - `cmd.<path>` and `cmd.<path>.long`: description and long description
+//     of a command, where path is names of cmds from root, like `cmd.app db`;
+//   - `flag.<name>`: description of a flag, name is its long name,
+//     or short name if no long name, like `flag.verbose`;
+//   - `section.<name>`: heading of flags, like `section.Options`;
+//   - `flagrouter.<code>`: built-in texts, like `flagrouter.usage`; for warnings
+//     and errors msg is a format string, and its verbs should be kept.
+//
+// Errors of flags.FlagSet, like unknown options, are not translated.
+func (r *Router) SetTranslator(fn func(key, msg string) string) {
+	r.translator = fn
+}
+
+func translate(fn func(key, msg string) string, key, msg string) string {
+	if fn == nil {
+		return msg
+	}
+	return fn(key, msg)
+}
+
+func (r *Router) tr(key, msg string) string {
+	return translate(r.translator, key, msg)
+}
+
+func (st *state) tr(key, msg string) string {
+	return translate(st.translator, key, msg)
+}
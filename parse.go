@@ -0,0 +1,585 @@
+package flagrouter
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/eachain/flags"
+)
+
+// optValue is a value of an option in args.
+type optValue struct {
+	opt string // option like `--long` or `--long=value`
+	val string
+	ok  bool // false if no value followed the option
+}
+
+// state records what a Run resolved from args.
+type state struct {
+	node        *node                        // the command to exec
+	path        []*node                      // cmds from root to node
+	set         map[*param]bool              // params set explicitly by args
+	args        []string                     // args passed to flags.FlagSet, positionals excluded
+	values      map[*param][]optValue        // values of params parsed by router
+	positionals []string                     // positional arguments
+	unknown     []string                     // unknown subcommand and its args, see HandleUnknown
+	stdin       io.Reader                    // nil after read by a flag tagged fromstdin
+	stdout      io.Writer                    // see Out
+	dryRun      bool                         // stops before middlewares, see Resolve
+	dispatched  bool                         // the handler of node is called, see LastCommand
+	snap        map[any]reflect.Value        // values of params keyed by ptr, see snapshot
+	release     func()                       // lets other runs parse, see snapshot
+	translator  func(key, msg string) string // see SetTranslator
+	result      *string                      // returned by Run instead of usage, see SetResult
+	err         error                        // error occurred after flags.FlagSet parsed args
+}
+
+type stateKey struct{}
+
+func putState(ctx context.Context, st *state) context.Context {
+	return context.WithValue(ctx, stateKey{}, st)
+}
+
+// getState returns state of the running Run.
+func getState(ctx context.Context) *state {
+	st, _ := ctx.Value(stateKey{}).(*state)
+	return st
+}
+
+// snapshot records values of params of the resolved command, which are
+// bound to variables shared by runs. Options passed to middlewares and
+// handlers are built from it, so that other runs can parse args after it.
+func (st *state) snapshot() {
+	st.snap = make(map[any]reflect.Value, len(st.node.params))
+	for _, p := range st.node.params {
+		v := reflect.New(reflect.TypeOf(p.ptr).Elem()).Elem()
+		v.Set(cloneValue(reflect.ValueOf(p.ptr).Elem()))
+		st.snap[p.ptr] = v
+	}
+}
+
+// value returns the value of the param bound to ptr in st, or the bound
+// value itself if it is not in the snapshot.
+func (st *state) value(ptr any) reflect.Value {
+	if st != nil {
+		if v, ok := st.snap[ptr]; ok {
+			return v
+		}
+	}
+	return reflect.ValueOf(ptr).Elem()
+}
+
+// fail records the first error occurred in handlers, as a *CmdError.
+func (st *state) fail(err error) {
+	if st != nil && st.err == nil && err != nil {
+		st.err = &CmdError{path: st.cmdNames(), err: err}
+	}
+}
+
+// scan walks args the same way flags.FlagSet parses them,
+// to find out the command to exec, the params set explicitly,
+// and positional arguments, which flags.FlagSet does not know about.
+// Malformed args are left to flags.FlagSet to report.
+// Values of params parsed by router are recorded and removed from args,
+// so flags.FlagSet never parses them.
+//
+// A non-flag arg is a subcommand if it is a subcommand name and no positional
+// arguments found before, or else it is a positional argument if the command
+// accepts positional arguments.
+// All args after a bare `--` are positional arguments.
+// If prefix matching is allowed, a non-flag arg which is not a subcommand name
+// is a subcommand if it is a unique prefix of a subcommand name.
+// If the command has an unknown handler, a non-flag arg which is not
+// a subcommand name is an unknown subcommand, which ends scanning.
+// If unknown flags pass through, a flag unknown to the command is
+// a positional argument if the command accepts positional arguments.
+func (r *Router) scan(args []string) *state {
+	st := &state{
+		node:   r.root,
+		path:   []*node{r.root},
+		set:    make(map[*param]bool),
+		values: make(map[*param][]optValue),
+	}
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if st.node.defaultSub != nil && len(st.positionals) == 0 && !r.keepsCmd(st.node, arg) {
+			st.descend(st.node.defaultSub)
+		}
+		if len(st.positionals) > 0 && !r.interspersed {
+			st.positionals = append(st.positionals, args[i:]...)
+			break
+		}
+
+		if arg == "--" {
+			st.positionals = append(st.positionals, args[i+1:]...)
+			break
+		}
+
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			if len(st.positionals) == 0 {
+				c := st.node.lookupCmd(arg)
+				if c == nil && arg == "help" {
+					// flags.FlagSet returns ErrHelp
+					st.args = append(st.args, args[i:]...)
+					break
+				}
+				if c == nil && r.prefixMatch {
+					if c, st.err = st.node.lookupPrefix(arg, r.translator); st.err != nil {
+						break
+					}
+				}
+				if c != nil {
+					st.descend(c)
+					continue
+				}
+				if st.node.unknown != nil {
+					st.unknown = args[i:]
+					break
+				}
+			}
+			if st.node.acceptPositionals() {
+				st.positionals = append(st.positionals, arg)
+				continue
+			}
+			st.args = append(st.args, args[i:]...)
+			break
+		}
+
+		p, inline := st.node.lookupParam(arg)
+		if p == nil {
+			if split, ok := st.node.splitShorts(arg); ok {
+				args = append(args[:i:i], append(split, args[i+1:]...)...)
+				i--
+				continue
+			}
+		}
+		if p == nil && r.passthrough && arg != "-h" && arg != "--help" && st.node.acceptPositionals() {
+			st.positionals = append(st.positionals, arg)
+			continue
+		}
+		if p == nil {
+			st.args = append(st.args, args[i:]...)
+			break
+		}
+		st.set[p] = true
+		// flags.FlagSet knows no short flags with inline values like `-v=true`,
+		// and parses inline values of bools like `--verbose=1` without parseBool
+		isBool := reflect.TypeOf(p.ptr).Elem().Kind() == reflect.Bool
+		if p.custom || (inline && (isBool || !strings.HasPrefix(arg, "--"))) {
+			v := optValue{opt: arg}
+			if inline {
+				v.val, v.ok = arg[strings.Index(arg, "=")+1:], true
+			} else if p.boolFlag {
+				v.val, v.ok = "true", true
+			} else if i+1 < len(args) {
+				i++
+				v.val, v.ok = args[i], true
+			}
+			st.values[p] = append(st.values[p], v)
+			continue
+		}
+		st.args = append(st.args, arg)
+		if !inline && !isBool && i+1 < len(args) {
+			i++
+			st.args = append(st.args, args[i])
+		}
+	}
+	if i == len(args) && st.err == nil {
+		for st.node.defaultSub != nil && len(st.positionals) == 0 {
+			st.descend(st.node.defaultSub)
+		}
+	}
+	return st
+}
+
+// descend resolves subcommand c of the resolved cmd.
+func (st *state) descend(c *node) {
+	st.node = c
+	st.path = append(st.path, c)
+	st.args = append(st.args, c.name) // flags.FlagSet knows no alias
+}
+
+// keepsCmd reports whether arg keeps n as the resolved cmd, instead of
+// its default subcommand: subcommands of n, help and flags of n.
+func (r *Router) keepsCmd(n *node, arg string) bool {
+	switch arg {
+	case "help", "-h", "--help":
+		return true
+	}
+	if n.lookupCmd(arg) != nil {
+		return true
+	}
+	if r.prefixMatch && !strings.HasPrefix(arg, "-") {
+		if c, err := n.lookupPrefix(arg, nil); c != nil || err != nil {
+			return true
+		}
+	}
+	if p, _ := n.lookupParam(arg); p != nil {
+		return true
+	}
+	_, ok := n.splitShorts(arg)
+	return ok
+}
+
+// reset clears values of params left by last runs, because flags.FlagSet
+// never resets params parsed once, and appends slices to their old values.
+func (st *state) reset() {
+	for _, p := range st.node.params {
+		reflect.ValueOf(p.ptr).Elem().SetZero()
+	}
+}
+
+// applyDefaults sets defaults to flags not set by args,
+// which flags.FlagSet skips if they were parsed by last runs.
+func (st *state) applyDefaults() {
+	for _, p := range st.node.params {
+		if !st.set[p] && !p.positional() && p.dft != nil {
+			reflect.ValueOf(p.ptr).Elem().Set(cloneValue(reflect.ValueOf(p.dft)))
+		}
+	}
+}
+
+// mergeDefaults merges defaults to values set by args of params tagged
+// `merge:"append"`: values of slices are appended to defaults, and values of
+// maps override defaults, while values of slices of maps are appended.
+func (st *state) mergeDefaults() {
+	for _, p := range st.node.params {
+		if !p.appendDft || !st.set[p] || p.dft == nil || p.positional() {
+			continue
+		}
+		val := reflect.ValueOf(p.ptr).Elem()
+		dft := cloneValue(reflect.ValueOf(p.dft))
+		switch val.Kind() {
+		case reflect.Slice:
+			val.Set(reflect.AppendSlice(dft, val))
+		case reflect.Map:
+			iter := val.MapRange()
+			for iter.Next() {
+				e := iter.Value()
+				if ori := dft.MapIndex(iter.Key()); ori.IsValid() && e.Kind() == reflect.Slice {
+					e = reflect.AppendSlice(ori, e)
+				}
+				dft.SetMapIndex(iter.Key(), e)
+			}
+			val.Set(dft)
+		}
+	}
+}
+
+// cloneValue returns a deep copy of slice or map v, so that handlers modifying
+// their options never modify defaults. Other values are returned as is.
+func cloneValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		// elements like maps and slices are references too
+		if k := v.Type().Elem().Kind(); k == reflect.Slice || k == reflect.Map {
+			for i := 0; i < v.Len(); i++ {
+				c.Index(i).Set(cloneValue(v.Index(i)))
+			}
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), cloneValue(iter.Value()))
+		}
+		return c
+	}
+	return v
+}
+
+// applyValues parses values of params parsed by router. Like flags.FlagSet,
+// a later value overrides the former one, except that values of slices are
+// appended and values of maps are merged.
+func (st *state) applyValues() error {
+	for _, p := range st.node.params {
+		values, ok := st.values[p]
+		if !ok {
+			continue
+		}
+		val := reflect.ValueOf(p.ptr).Elem()
+		val.SetZero()
+		for _, v := range values {
+			if !v.ok {
+				return fmt.Errorf("%v: parse option %v: %w", st.cmdPath(), v.opt, ErrNoInputValue)
+			}
+			s, err := st.read(p, v.val)
+			if err != nil {
+				return fmt.Errorf("%v: parse option %v: %w", st.cmdPath(), v.opt, err)
+			}
+			if err := setValue(val, s, p.opts()); err != nil {
+				return fmt.Errorf("%v: parse option %v: %w", st.cmdPath(), v.opt, err)
+			}
+		}
+	}
+	return nil
+}
+
+// read returns value s of p, or content of file if p is tagged
+// `fromfile:"true"` and s is like `@path`, or content of stdin if p is tagged
+// `fromstdin:"true"` and s is `-`. Spaces around contents are trimmed.
+// Stdin can be read only once in a run.
+func (st *state) read(p *param, s string) (string, error) {
+	var data []byte
+	var err error
+	switch {
+	case p.fromFile && strings.HasPrefix(s, "@"):
+		data, err = os.ReadFile(s[1:])
+	case p.fromStdin && s == "-":
+		if st.stdin == nil {
+			return "", errors.New("stdin has been read by another option")
+		}
+		data, err = io.ReadAll(st.stdin)
+		st.stdin = nil
+	default:
+		return s, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// setValue parses s and sets it to val.
+// Values of flag.Value are set by their Set method, like std library.
+func setValue(val reflect.Value, s string, opts parseOpts) error {
+	if isFlagValue(val.Type()) && !opts.json {
+		return val.Addr().Interface().(flag.Value).Set(s)
+	}
+	x, err := parseValue(val.Type(), s, opts)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(x).Convert(val.Type())
+	if opts.json {
+		val.Set(v)
+		return nil
+	}
+	switch val.Kind() {
+	case reflect.Slice:
+		val.Set(reflect.AppendSlice(val, v))
+	case reflect.Map:
+		if val.IsNil() {
+			val.Set(reflect.MakeMap(val.Type()))
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			e := iter.Value()
+			if ori := val.MapIndex(iter.Key()); ori.IsValid() && e.Kind() == reflect.Slice {
+				e = reflect.AppendSlice(ori, e)
+			}
+			val.SetMapIndex(iter.Key(), e)
+		}
+	default:
+		val.Set(v)
+	}
+	return nil
+}
+
+func (n *node) acceptPositionals() bool {
+	for _, p := range n.params {
+		if p.positional() {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPositionals sets positional arguments to positional params in order,
+// params without argument are set to their defaults.
+// If the last positional param is a slice, it receives all the rest arguments.
+// Extra arguments are ignored.
+func (st *state) applyPositionals() error {
+	var params []*param
+	for _, p := range st.node.params {
+		if p.positional() {
+			params = append(params, p)
+		}
+	}
+
+	if err := st.checkPositionals(params); err != nil {
+		return err
+	}
+
+	args := st.positionals
+	for i, p := range params {
+		val := reflect.ValueOf(p.ptr).Elem()
+		if len(args) == 0 {
+			if p.dft != nil {
+				val.Set(cloneValue(reflect.ValueOf(p.dft)))
+			} else {
+				val.SetZero()
+			}
+			continue
+		}
+
+		st.set[p] = true
+		if i == len(params)-1 && val.Kind() == reflect.Slice {
+			elemTyp := val.Type().Elem()
+			ls := reflect.MakeSlice(val.Type(), 0, len(args))
+			for _, arg := range args {
+				elem, err := parseValue(elemTyp, arg, p.opts())
+				if err != nil {
+					return fmt.Errorf("%v: parse argument %q: %w", st.cmdPath(), arg, err)
+				}
+				ls = reflect.Append(ls, reflect.ValueOf(elem).Convert(elemTyp))
+			}
+			val.Set(ls)
+			break
+		}
+
+		x, err := parseValue(val.Type(), args[0], p.opts())
+		if err != nil {
+			return fmt.Errorf("%v: parse argument %q: %w", st.cmdPath(), args[0], err)
+		}
+		val.Set(reflect.ValueOf(x).Convert(val.Type()))
+		args = args[1:]
+	}
+	return nil
+}
+
+// checkPositionals checks the count of positional arguments: there must be
+// no more of them than params, unless the last one is a slice, which receives
+// all the rest, and no less than params without defaults. The slice is left to
+// applyRequired, and params which may be filled later, by environment
+// variables or prompts, are not counted.
+func (st *state) checkPositionals(params []*param) error {
+	n := len(params)
+	if n == 0 {
+		return nil
+	}
+	rest := reflect.TypeOf(params[n-1].ptr).Elem().Kind() == reflect.Slice
+	least := 0
+	for i, p := range params {
+		if rest && i == n-1 || p.dft != nil || p.zeroDft || p.env != "" || p.secret {
+			continue
+		}
+		least = i + 1
+	}
+
+	got := len(st.positionals)
+	switch {
+	case !rest && least == n && got != n:
+		return fmt.Errorf(st.tr("flagrouter.arguments_exact",
+			"%v: expected %v arguments, got %v"), st.cmdPath(), n, got)
+	case got < least:
+		return fmt.Errorf(st.tr("flagrouter.arguments_at_least",
+			"%v: expected at least %v arguments, got %v"), st.cmdPath(), least, got)
+	case !rest && got > n:
+		return fmt.Errorf(st.tr("flagrouter.arguments_at_most",
+			"%v: expected at most %v arguments, got %v"), st.cmdPath(), n, got)
+	}
+	return nil
+}
+
+func (n *node) lookupCmd(name string) *node {
+	for _, c := range n.cmds {
+		if c.name == name || slices.Contains(c.aliases, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// lookupPrefix finds the only cmd whose name or alias starts with prefix,
+// or returns an error if more than one cmd matches.
+func (n *node) lookupPrefix(prefix string, tr func(key, msg string) string) (*node, error) {
+	if prefix == "" {
+		return nil, nil
+	}
+	var cmds []*node
+	var names []string
+	for _, c := range n.cmds {
+		if strings.HasPrefix(c.name, prefix) ||
+			slices.ContainsFunc(c.aliases, func(a string) bool { return strings.HasPrefix(a, prefix) }) {
+			cmds = append(cmds, c)
+			names = append(names, c.name)
+		}
+	}
+	if len(cmds) > 1 {
+		return nil, fmt.Errorf(translate(tr, "flagrouter.ambiguous_command",
+			"flagrouter: ambiguous command %q, could be: %v"), prefix, strings.Join(names, ", "))
+	}
+	if len(cmds) == 1 {
+		return cmds[0], nil
+	}
+	return nil, nil
+}
+
+// lookupParam finds param by arg like `-s`, `--long` or `--long=value`.
+func (n *node) lookupParam(arg string) (p *param, inline bool) {
+	if strings.HasPrefix(arg, "--") {
+		for _, p := range n.params {
+			if p.long == flags.NoLong {
+				continue
+			}
+			if arg == "--"+p.long {
+				return p, false
+			}
+			if strings.HasPrefix(arg, "--"+p.long+"=") {
+				return p, true
+			}
+		}
+		return nil, false
+	}
+
+	for _, p := range n.params {
+		if p.short == flags.NoShort {
+			continue
+		}
+		if arg == "-"+string(p.short) {
+			return p, false
+		}
+		if strings.HasPrefix(arg, "-"+string(p.short)+"=") {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// splitShorts splits combined short flags like `-vq` to `-v -q`.
+// Flags but the last one must be bools, and the value of the last one
+// can follow it, like `-vn5` or `-vn=5` for `-v -n 5` or `-v -n=5`.
+// It returns false if arg is not combined short flags of n.
+func (n *node) splitShorts(arg string) ([]string, bool) {
+	if len(arg) < 3 || arg[0] != '-' || arg[1] == '-' {
+		return nil, false
+	}
+	var split []string
+	for j := 1; j < len(arg); j++ {
+		short := "-" + arg[j:j+1]
+		p, _ := n.lookupParam(short)
+		if p == nil {
+			return nil, false
+		}
+		if j+1 < len(arg) && arg[j+1] == '=' {
+			return append(split, short+arg[j+1:]), true
+		}
+		if reflect.TypeOf(p.ptr).Elem().Kind() == reflect.Bool || p.boolFlag {
+			split = append(split, short)
+			continue
+		}
+		if j+1 < len(arg) {
+			return append(split, short, arg[j+1:]), true
+		}
+		return append(split, short), true
+	}
+	return split, true
+}
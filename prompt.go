@@ -0,0 +1,43 @@
+package flagrouter
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// applyRequired checks params tagged `required:"true"` are not empty after
+// args, defaults and config applied. Empty secret strings are prompted for,
+// without echo, if stdin is a terminal.
+func (r *Router) applyRequired(st *state) error {
+	for _, p := range st.node.params {
+		if !p.required {
+			continue
+		}
+		val := reflect.ValueOf(p.ptr).Elem()
+		if !val.IsZero() && !((val.Kind() == reflect.Slice || val.Kind() == reflect.Map) && val.Len() == 0) {
+			continue
+		}
+
+		f, ok := st.stdin.(*os.File)
+		if !p.secret || val.Kind() != reflect.String || !ok || !isTerminal(int(f.Fd())) {
+			if p.positional() {
+				return fmt.Errorf(r.tr("flagrouter.required_argument", "flagrouter: argument %v is required"), p.name())
+			}
+			return fmt.Errorf(r.tr("flagrouter.required_option", "flagrouter: option %v is required"), p.name())
+		}
+
+		prompt := p.desc
+		if prompt == "" {
+			prompt = p.name()
+		}
+		fmt.Fprintf(r.stderr(), "%v: ", prompt)
+		secret, err := readPassword(int(f.Fd()))
+		fmt.Fprintln(r.stderr())
+		if err != nil {
+			return fmt.Errorf("flagrouter: read %v: %w", p.name(), err)
+		}
+		val.SetString(string(secret))
+	}
+	return nil
+}
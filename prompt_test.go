@@ -0,0 +1,54 @@
+package flagrouter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRequired(t *testing.T) {
+	type required struct {
+		User     string   `long:"user" required:"true"`
+		Password string   `long:"password" secret:"true" required:"true" desc:"password of user"`
+		Files    []string `required:"true"`
+	}
+
+	r := New("login", "")
+	r.SetInput(strings.NewReader("not a terminal\n"))
+	r.Handle(func(*required) {})
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--user", "root", "--password", "123", "a"}, ""},
+		{[]string{"--password", "123", "a"}, "flagrouter: option --user is required"},
+		{[]string{"--user", "root", "a"}, "flagrouter: option --password is required"},
+		{[]string{"--user", "root", "--password", "123"}, "flagrouter: argument FILES is required"},
+	} {
+		_, err := r.Run(context.Background(), c.args...)
+		if c.err == "" && err != nil {
+			t.Fatalf("required %q: %v", c.args, err)
+		}
+		if c.err != "" && (err == nil || err.Error() != c.err) {
+			t.Fatalf("required %q: %v, want %v", c.args, err, c.err)
+		}
+	}
+}
+
+func TestSecretUsage(t *testing.T) {
+	r := New("secret", "")
+	r.Handle(func(struct {
+		Token string `long:"token" dft:"s3cret" secret:"true"`
+	}) {
+	})
+
+	usage, err := r.Run(context.Background(), "-h")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("secret usage: run: %v", err)
+	}
+	if strings.Contains(usage, "s3cret") || !strings.Contains(usage, "--token string (default: ******)") {
+		t.Fatalf("secret usage:\n%v", usage)
+	}
+}
@@ -0,0 +1,61 @@
+package flagrouter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Provide registers value as a dependency of handlers, such as a database
+// handle or a client: an arg of handlers of the type of value, or of
+// an interface value implements, receives value instead of flags.
+// A handler can receive context.Context first, and then any number of
+// provided values and options, like `func(ctx, *sql.DB, *options) error`.
+//
+// A value must be provided before registering handlers which receive it,
+// and providing a value of the same type again replaces the value
+// received by later runs. An interface arg implemented by more than one
+// provided value is an error of registration.
+func (r *Router) Provide(value any) {
+	typ := reflect.TypeOf(value)
+	if typ == nil {
+		panic(errors.New("flagrouter: provide nil"))
+	}
+	if r.provided == nil {
+		r.provided = make(map[reflect.Type]any)
+	}
+	r.provided[typ] = value
+	r.record(func(c *Router) { c.Provide(value) })
+}
+
+// provider returns the type of the provided value received by arg,
+// ok is false if none.
+func (r *Router) provider(arg reflect.Type) (typ reflect.Type, ok bool, err error) {
+	if _, ok = r.provided[arg]; ok {
+		return arg, true, nil
+	}
+	if arg.Kind() != reflect.Interface {
+		return nil, false, nil
+	}
+
+	var found []reflect.Type
+	for typ := range r.provided {
+		if typ.Implements(arg) {
+			found = append(found, typ)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, false, fmt.Errorf("no provided value of %v", arg)
+	case 1:
+		return found[0], true, nil
+	}
+	names := make([]string, len(found))
+	for i, typ := range found {
+		names[i] = typ.String()
+	}
+	sort.Strings(names)
+	return nil, false, fmt.Errorf("more than one provided value of %v: %v", arg, strings.Join(names, ", "))
+}
@@ -0,0 +1,71 @@
+package flagrouter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type store interface {
+	Get(key string) string
+}
+
+type mapStore map[string]string
+
+func (m mapStore) Get(key string) string { return m[key] }
+
+type client struct{ name string }
+
+func TestProvide(t *testing.T) {
+	var got []string
+	r := New("app", "")
+	r.Provide(mapStore{"a": "1"})
+	r.Provide(&client{name: "c1"})
+	r.HandleGroup("get", "", func(ctx context.Context, s store, c *client, opt *struct {
+		Key string `long:"key"`
+	}) error {
+		if ctx == nil {
+			return fmt.Errorf("nil context")
+		}
+		got = append(got, c.name+":"+s.Get(opt.Key))
+		return nil
+	})
+	r.HandleGroup("name", "", func(c *client) { got = append(got, c.name) })
+
+	ctx := context.Background()
+	if _, err := r.Run(ctx, "get", "--key", "a"); err != nil {
+		t.Fatalf("provide: run: %v", err)
+	}
+	r.Provide(&client{name: "c2"})
+	if _, err := r.Run(ctx, "name"); err != nil {
+		t.Fatalf("provide: run: %v", err)
+	}
+	if strings.Join(got, ",") != "c1:1,c2" {
+		t.Fatalf("provide: %q", got)
+	}
+
+	for _, c := range []struct {
+		handler any
+		err     string
+	}{
+		{func(fmt.Stringer) {}, "no provided value of fmt.Stringer"},
+		{func(any) {}, "more than one provided value of interface {}"},
+		{func(*client, context.Context) {}, "context.Context as the first arg"},
+	} {
+		func() {
+			defer func() {
+				if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), c.err) {
+					t.Fatalf("provide: expect panic %q, got %v", c.err, e)
+				}
+			}()
+			r.Handle(c.handler)
+		}()
+	}
+
+	clone := r.Clone()
+	got = nil
+	if _, err := clone.Run(ctx, "name"); err != nil || strings.Join(got, ",") != "c2" {
+		t.Fatalf("provide: clone: %v, %q", err, got)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package flagrouter
+
+import "errors"
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+func readPassword(fd int) ([]byte, error) {
+	return nil, errors.New("reading password is not supported on this platform")
+}
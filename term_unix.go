@@ -0,0 +1,68 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package flagrouter
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func getTermios(fd int) (*syscall.Termios, error) {
+	t := new(syscall.Termios)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), ioctlGetTermios, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return t, nil
+}
+
+func setTermios(fd int, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), ioctlSetTermios, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal reports whether fd is a terminal.
+func isTerminal(fd int) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+// readPassword reads a line from terminal fd without echo.
+func readPassword(fd int) ([]byte, error) {
+	old, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+	t := *old
+	t.Lflag &^= syscall.ECHO
+	t.Lflag |= syscall.ICANON | syscall.ISIG
+	t.Iflag |= syscall.ICRNL
+	if err = setTermios(fd, &t); err != nil {
+		return nil, err
+	}
+	defer setTermios(fd, old)
+
+	var line []byte
+	var b [1]byte
+	for {
+		n, err := syscall.Read(fd, b[:])
+		if n > 0 {
+			if b[0] == '\n' {
+				return line, nil
+			}
+			line = append(line, b[0])
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return line, nil
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package flagrouter
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/eachain/flags"
+)
+
+// decodeTOML decodes data by github.com/BurntSushi/toml.
+//
+// Tables are decoded as map[string]any, arrays and arrays of tables as []any,
+// and all scalars as strings, like decodeYAML, to be converted by parseDefault
+// later. Datetimes are formatted like flags.DateTime in local time zone.
+func decodeTOML(data []byte) (map[string]any, error) {
+	var m map[string]any
+	if _, err := toml.Decode(string(data), &m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return map[string]any{}, nil
+	}
+	return tomlValue(m).(map[string]any), nil
+}
+
+func tomlValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			v[k] = tomlValue(x)
+		}
+		return v
+	case []map[string]any:
+		ls := make([]any, len(v))
+		for i, x := range v {
+			ls[i] = tomlValue(x)
+		}
+		return ls
+	case []any:
+		for i, x := range v {
+			v[i] = tomlValue(x)
+		}
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		// zones of local datetimes, see github.com/BurntSushi/toml/internal
+		switch v.Location().String() {
+		case "date-local":
+			return v.Format(time.DateOnly)
+		case "time-local":
+			return v.Format("15:04:05.999999999")
+		case "datetime-local":
+			return v.Format(flags.DateTime + ".999999999")
+		}
+		return v.Local().Format(flags.DateTime + ".999999999")
+	}
+	return v
+}
@@ -0,0 +1,81 @@
+package flagrouter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeTOML(t *testing.T) {
+	m, err := decodeTOML([]byte(`# comment
+str = "hello \"world\"" # comment
+literal = 'C:\path'
+num = 1_000
+float = 1.5e3
+bool = true
+list = [
+  1, "2", # comment
+  [3],
+]
+a.b = "dotted"
+inline = {x = 1, y.z = "w"}
+multi = """
+a
+b"""
+date = 2024-01-02
+datetime = 2024-01-02T15:04:05
+
+[db]
+log-level = "debug"
+
+[db.migrate]
+steps = 0x10
+"quoted key" = 'v'
+
+[[hosts]]
+name = "a"
+
+[[hosts]]
+name = "b"
+`))
+	if err != nil {
+		t.Fatalf("decode toml: %v", err)
+	}
+
+	want := map[string]any{
+		"str":      `hello "world"`,
+		"literal":  `C:\path`,
+		"num":      "1000",
+		"float":    "1500",
+		"bool":     "true",
+		"list":     []any{"1", "2", []any{"3"}},
+		"a":        map[string]any{"b": "dotted"},
+		"inline":   map[string]any{"x": "1", "y": map[string]any{"z": "w"}},
+		"multi":    "a\nb",
+		"date":     "2024-01-02",
+		"datetime": "2024-01-02T15:04:05",
+		"db": map[string]any{
+			"log-level": "debug",
+			"migrate":   map[string]any{"steps": "16", "quoted key": "v"},
+		},
+		"hosts": []any{map[string]any{"name": "a"}, map[string]any{"name": "b"}},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("decode toml: %#v", m)
+	}
+
+	for _, bad := range []string{
+		"a = [1, 2\n",
+		"a = \"b\n",
+		"a = b\n",
+		"a = 1\na = 2\n",
+		"a\n",
+		"a = 1 2\n",
+		"[a]\n[a]\n",
+		"a = 1\n[a]\n",
+		"a =\n",
+	} {
+		if _, err = decodeTOML([]byte(bad)); err == nil {
+			t.Fatalf("decode toml %q: no error", bad)
+		}
+	}
+}
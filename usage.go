@@ -0,0 +1,322 @@
+package flagrouter
+
+import (
+	"bytes"
+	"encoding"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eachain/flags"
+)
+
+// EnableHelpAll registers a persistent flag `--help-all` to all cmds,
+// which shows help of the cmd and all its subcommands, and Run returns ErrHelp.
+func (r *Router) EnableHelpAll() {
+	if r.helpAll != nil {
+		return
+	}
+	r.helpAll = &param{
+		field:      "HelpAll",
+		ptr:        new(bool),
+		long:       "help-all",
+		desc:       "show help of all commands",
+		persistent: true,
+	}
+	r.root.add(r.helpAll)
+	r.record(func(c *Router) { c.EnableHelpAll() })
+}
+
+// usageAll returns help of the resolved command and all its subcommands,
+// in order of registration, depth first.
+func (st *state) usageAll() string {
+	var b strings.Builder
+	b.WriteString(st.usage())
+	for _, c := range st.node.cmds {
+		sub := &state{node: c, path: append(st.path[:len(st.path):len(st.path)], c), translator: st.translator}
+		b.WriteString("\n\n")
+		b.WriteString(sub.usageAll())
+	}
+	return b.String()
+}
+
+// usage returns help of the root command.
+func (r *Router) usage() string {
+	return (&state{node: r.root, path: []*node{r.root}, translator: r.translator}).usage()
+}
+
+// usage returns help of the resolved command, in the same format
+// as flags.FlagSet, with placeholders and positional arguments.
+func (st *state) usage() string {
+	n := st.node
+	w := new(bytes.Buffer)
+
+	name := st.cmdPath()
+	fmt.Fprintf(w, "%v - %v\n\n", name, st.tr("cmd."+name, n.desc))
+	if n.longDesc != "" {
+		fmt.Fprintf(w, "%v\n\n", st.tr("cmd."+name+".long", n.longDesc))
+	}
+
+	var options, positionals []*param
+	for _, p := range n.params {
+		if p.positional() {
+			positionals = append(positionals, p)
+		} else {
+			options = append(options, p)
+		}
+	}
+	handled := n.handler != nil || n.unknown != nil
+
+	fmt.Fprintf(w, "%v:\n", st.tr("flagrouter.usage", "Usage"))
+	fmt.Fprintf(w, "  %v", name)
+	if handled && len(options) > 0 {
+		if len(n.cmds) > 0 {
+			fmt.Fprintf(w, " [option|command]")
+		} else {
+			fmt.Fprintf(w, " [option]")
+		}
+	} else if len(n.cmds) > 0 {
+		fmt.Fprintf(w, " [command]")
+	}
+	if handled {
+		for _, p := range positionals {
+			fmt.Fprintf(w, " %v", p.holder)
+			if reflect.TypeOf(p.ptr).Elem().Kind() == reflect.Slice {
+				fmt.Fprintf(w, "...")
+			}
+		}
+	}
+	fmt.Fprintf(w, "\n\n")
+
+	if handled && slices.ContainsFunc(positionals, func(p *param) bool { return p.desc != "" || p.longDesc != "" }) {
+		fmt.Fprintf(w, "%v:\n", st.tr("flagrouter.arguments", "Arguments"))
+		for _, p := range positionals {
+			st.writeArgument(w, p)
+		}
+	}
+
+	if handled && len(options) > 0 {
+		for _, sec := range sections(options) {
+			fmt.Fprintf(w, "%v:\n", st.tr("section."+sec.name, sec.name))
+			for _, p := range sec.params {
+				st.writeOption(w, p)
+			}
+		}
+	}
+
+	if len(n.cmds) > 0 {
+		fmt.Fprintf(w, "%v:\n", st.tr("flagrouter.commands", "Commands"))
+		for _, cmd := range n.cmds {
+			fmt.Fprintf(w, "  %v", cmd.name)
+			if len(cmd.aliases) > 0 {
+				fmt.Fprintf(w, " (%v: %v)", st.tr("flagrouter.aliases", "aliases"), strings.Join(cmd.aliases, ", "))
+			}
+			if cmd == n.defaultSub {
+				fmt.Fprintf(w, " (%v)", st.tr("flagrouter.default", "default"))
+			}
+			fmt.Fprintln(w)
+			if desc := st.tr("cmd."+name+" "+cmd.name, cmd.desc); desc != "" {
+				for _, line := range strings.Split(desc, "\n") {
+					fmt.Fprintf(w, "    %v\n", line)
+				}
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	return string(bytes.TrimSpace(w.Bytes()))
+}
+
+// section is a group of options shown under a heading in help.
+type section struct {
+	name   string
+	params []*param
+}
+
+// sections groups options by tag `section` in order of their first
+// appearance, options without section are grouped under "Options".
+func sections(options []*param) []*section {
+	var secs []*section
+	for _, p := range options {
+		name := p.section
+		if name == "" {
+			name = "Options"
+		}
+		i := slices.IndexFunc(secs, func(sec *section) bool { return sec.name == name })
+		if i < 0 {
+			i = len(secs)
+			secs = append(secs, &section{name: name})
+		}
+		secs[i].params = append(secs[i].params, p)
+	}
+	return secs
+}
+
+// writeOption writes help of option p to w, in the same format as flags.FlagSet.
+func (st *state) writeOption(w io.Writer, p *param) {
+	fmt.Fprintf(w, "  ")
+	if p.short != flags.NoShort {
+		fmt.Fprintf(w, "-%c", p.short)
+	}
+	if p.long != flags.NoLong {
+		if p.short != flags.NoShort {
+			fmt.Fprintf(w, ", ")
+		}
+		fmt.Fprintf(w, "--%v", p.long)
+	}
+	fmt.Fprintf(w, " %v", p.placeholder())
+	st.writeDefault(w, p)
+	fmt.Fprintln(w)
+	desc := p.desc
+	if p.longDesc != "" {
+		desc = p.longDesc
+	}
+	if desc = st.tr("flag."+strings.TrimLeft(p.name(), "-"), desc); desc != "" {
+		for _, line := range strings.Split(desc, "\n") {
+			fmt.Fprintf(w, "    %v\n", line)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// writeArgument writes help of positional argument p to w, like writeOption.
+func (st *state) writeArgument(w io.Writer, p *param) {
+	fmt.Fprintf(w, "  %v", p.holder)
+	if reflect.TypeOf(p.ptr).Elem().Kind() == reflect.Slice {
+		fmt.Fprintf(w, "...")
+	}
+	st.writeDefault(w, p)
+	fmt.Fprintln(w)
+	desc := p.desc
+	if p.longDesc != "" {
+		desc = p.longDesc
+	}
+	if desc = st.tr("flag."+p.holder, desc); desc != "" {
+		for _, line := range strings.Split(desc, "\n") {
+			fmt.Fprintf(w, "    %v\n", line)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// writeDefault writes the default of p to w, if meaningful: defaults of
+// required params are never shown, and zero values only if tag dft is
+// given explicitly empty, like `dft:""` of a string.
+func (st *state) writeDefault(w io.Writer, p *param) {
+	if p.required || (!p.zeroDft && (p.dft == nil || reflect.ValueOf(p.dft).IsZero())) {
+		return
+	}
+	dft := st.tr("flagrouter.default", "default")
+	switch {
+	case p.secret:
+		fmt.Fprintf(w, " (%v: ******)", dft)
+	case p.char && p.dft == nil:
+		fmt.Fprintf(w, " (%v: %v)", dft, formatChar(reflect.Zero(reflect.TypeOf(p.ptr).Elem())))
+	case p.char:
+		fmt.Fprintf(w, " (%v: %v)", dft, formatChar(reflect.ValueOf(p.dft)))
+	case p.dft == nil:
+		fmt.Fprintf(w, " (%v: %v)", dft, formatValue(reflect.Zero(reflect.TypeOf(p.ptr).Elem()).Interface()))
+	case p.encoding != "":
+		fmt.Fprintf(w, " (%v: %v)", dft, encodeBytes(reflect.ValueOf(p.dft).Bytes(), p.encoding))
+	default:
+		fmt.Fprintf(w, " (%v: %v)", dft, formatValue(p.dft))
+	}
+}
+
+// placeholder returns placeholder of p's value, which is the type name
+// like flags.FlagSet if not specified by tag `placeholder`.
+func (p *param) placeholder() string {
+	if p.holder != "" {
+		return p.holder
+	}
+	switch p.encoding {
+	case "":
+	case "raw":
+		return "string"
+	default:
+		return p.encoding
+	}
+	typ := reflect.TypeOf(p.ptr).Elem()
+	if i, ok := nullField(typ); ok {
+		typ = typ.Field(i).Type
+	}
+	switch typ {
+	case typDuration:
+		if p.unit != "" {
+			return fmt.Sprintf("duration, unit: %v", p.unit)
+		}
+		return "duration"
+	case typDateTime:
+		return fmt.Sprintf("datetime, format: %q", flags.DateTime)
+	case typRegexp:
+		return "regexp"
+	case typMAC:
+		return "mac"
+	case typEmail, typEmailVal:
+		return "email"
+	}
+	if p.char {
+		return strings.ReplaceAll(typ.String(), "int32", "char")
+	}
+	return typ.String()
+}
+
+// formatChar formats v of tag char, whose runes are quoted like '\t'.
+func formatChar(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Int32:
+		return strconv.QuoteRune(rune(v.Int()))
+	case reflect.Slice:
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = formatChar(v.Index(i))
+		}
+		return "[" + strings.Join(elems, " ") + "]"
+	}
+	return formatValue(v.Interface())
+}
+
+// formatValue formats v for help and dumps. Values of encoding.TextMarshaler
+// are formatted by MarshalText, and quoted like strings.
+func formatValue(v any) string {
+	if v != nil {
+		if x, valid, ok := nullValue(reflect.ValueOf(v)); ok {
+			if !valid {
+				return "null"
+			}
+			return formatValue(x.Interface())
+		}
+	}
+	switch x := v.(type) {
+	case string:
+		return strconv.Quote(x)
+	case time.Time:
+		return strconv.Quote(x.Format(flags.DateTime))
+	case mail.Address:
+		return x.String()
+	}
+
+	m, ok := v.(encoding.TextMarshaler)
+	if !ok && v != nil {
+		ptr := reflect.New(reflect.TypeOf(v))
+		ptr.Elem().Set(reflect.ValueOf(v))
+		m, ok = ptr.Interface().(encoding.TextMarshaler)
+	}
+	if ok {
+		if text, err := m.MarshalText(); err == nil {
+			return strconv.Quote(string(text))
+		}
+	}
+	if v != nil && isFlagValue(reflect.TypeOf(v)) {
+		ptr := reflect.New(reflect.TypeOf(v))
+		ptr.Elem().Set(reflect.ValueOf(v))
+		return ptr.Interface().(flag.Value).String()
+	}
+	return fmt.Sprint(v)
+}
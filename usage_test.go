@@ -0,0 +1,353 @@
+package flagrouter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUsageSameAsFlags(t *testing.T) {
+	type persist struct {
+		Verbose bool `short:"v" long:"verbose" desc:"verbose\noutput" persistent:"true"`
+	}
+
+	r := New("usage", "usage desc")
+	r.Use(func(*persist) {})
+	r.Handle(func(*options) {})
+	r.Stmt(func() {
+		r.HandleGroup("stmt", "the stmt cmd", func() {})
+	})
+	r.Group("group", "the group", func() {
+		r.HandleGroup("leaf", "", func(*struct {
+			Name string `long:"name" desc:"the name"`
+		}) {
+		})
+	})
+
+	for _, args := range [][]string{nil, {"stmt"}, {"group"}, {"group", "leaf"}} {
+		st := r.scan(args)
+		if got, want := st.usage(), st.node.fs.Usage(); got != want {
+			t.Fatalf("usage %q:\n%v\nwant:\n%v", args, got, want)
+		}
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+	type placeholders struct {
+		Output string   `short:"o" long:"output" placeholder:"FILE" desc:"output file"`
+		Level  int      `long:"level" dft:"1"`
+		Src    string   `placeholder:"SOURCE"`
+		Dst    []string `desc:"destinations"`
+	}
+
+	r := New("copy", "copy files")
+	r.Handle(func(*placeholders) {})
+
+	usage, err := r.Run(context.Background(), "-h")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("placeholder: run: %v", err)
+	}
+	for _, s := range []string{
+		"copy [option] SOURCE DST...\n",
+		"-o, --output FILE\n    output file\n",
+		"--level int (default: 1)",
+	} {
+		if !strings.Contains(usage, s) {
+			t.Fatalf("placeholder: usage does not contain %q:\n%v", s, usage)
+		}
+	}
+}
+
+func TestLongDesc(t *testing.T) {
+	type described struct {
+		Mode string `long:"mode" desc:"run mode" long_desc:"run mode, one of:\n  fast\n  safe"`
+	}
+
+	r := New("app", "the app")
+	r.Group("db", "manage database", func() {
+		r.SetLongDesc("Manage the database.\nMigrations run in order.")
+		r.Handle(func(*described) {})
+	})
+
+	usage, err := r.Run(context.Background(), "-h")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("long desc: run: %v", err)
+	}
+	if !strings.Contains(usage, "  db\n    manage database") || strings.Contains(usage, "Migrations") {
+		t.Fatalf("long desc: root usage:\n%v", usage)
+	}
+
+	usage, err = r.Run(context.Background(), "db", "-h")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("long desc: run db: %v", err)
+	}
+	for _, s := range []string{
+		"app db - manage database\n\nManage the database.\nMigrations run in order.\n\nUsage:",
+		"--mode string\n    run mode, one of:\n      fast\n      safe",
+	} {
+		if !strings.Contains(usage, s) {
+			t.Fatalf("long desc: db usage does not contain %q:\n%v", s, usage)
+		}
+	}
+}
+
+func TestHelpEveryLevel(t *testing.T) {
+	r := New("tool", "the tool")
+	r.Group("group", "the group", func() {
+		r.Group("sub", "the sub group", func() {
+			r.HandleGroup("cmd", "the cmd", func(*struct {
+				Force bool     `short:"f" long:"force"`
+				Files []string `placeholder:"FILE"`
+			}) {
+			})
+		})
+	})
+
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-h"}, "tool - the tool\n\nUsage:\n  tool [command]\n\nCommands:\n  group\n    the group"},
+		{[]string{"help"}, "tool - the tool\n\nUsage:\n  tool [command]\n\nCommands:\n  group\n    the group"},
+		{[]string{"group", "--help"}, "tool group - the group\n\nUsage:\n  tool group [command]\n\nCommands:\n  sub\n    the sub group"},
+		{[]string{"group", "sub", "-h"}, "tool group sub - the sub group\n\nUsage:\n  tool group sub [command]\n\nCommands:\n  cmd\n    the cmd"},
+		{[]string{"group", "sub", "cmd", "--help"}, "tool group sub cmd - the cmd\n\nUsage:\n  tool group sub cmd [option] FILE...\n\nOptions:\n  -f, --force bool"},
+		{[]string{"group", "sub", "cmd", "a", "-h"}, "tool group sub cmd - the cmd\n\nUsage:"},
+	} {
+		usage, err := r.Run(context.Background(), c.args...)
+		if !errors.Is(err, ErrHelp) {
+			t.Fatalf("help %q: %v", c.args, err)
+		}
+		if !strings.HasPrefix(usage, c.want) {
+			t.Fatalf("help %q:\n%v\nwant:\n%v", c.args, usage, c.want)
+		}
+	}
+}
+
+func TestHelpAll(t *testing.T) {
+	r := New("tool", "the tool")
+	r.Group("db", "database", func() {
+		r.HandleGroup("migrate", "run migrations", func(*struct {
+			Steps int `long:"steps"`
+		}) {
+		})
+	})
+	r.EnableHelpAll()
+	r.HandleGroup("version", "show version", func() {})
+
+	usage, err := r.Run(context.Background(), "--help-all")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("help all: run: %v", err)
+	}
+	var last int
+	for _, s := range []string{
+		"tool - the tool\n",
+		"tool db - database\n",
+		"tool db migrate - run migrations\n",
+		"--steps int\n",
+		"--help-all bool\n",
+		"tool version - show version\n",
+	} {
+		i := strings.Index(usage, s)
+		if i < last {
+			t.Fatalf("help all: %q not found in order:\n%v", s, usage)
+		}
+		last = i
+	}
+
+	usage, err = r.Run(context.Background(), "db", "--help-all")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("help all: run db: %v", err)
+	}
+	if !strings.HasPrefix(usage, "tool db - database\n") || strings.Contains(usage, "tool version") {
+		t.Fatalf("help all: db:\n%v", usage)
+	}
+}
+
+func TestSection(t *testing.T) {
+	type sectioned struct {
+		Verbose bool   `short:"v" long:"verbose"`
+		Host    string `long:"host" section:"Networking"`
+		Cert    string `long:"cert" section:"TLS"`
+		Port    int    `long:"port" section:"Networking"`
+		Quiet   bool   `short:"q"`
+	}
+
+	r := New("app", "")
+	r.Handle(func(*sectioned) {})
+
+	usage, err := r.Run(context.Background(), "-h")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("section: run: %v", err)
+	}
+	want := "Options:\n  -v, --verbose bool\n\n  -q bool\n\n" +
+		"Networking:\n  --host string\n\n  --port int\n\n" +
+		"TLS:\n  --cert string"
+	if !strings.HasSuffix(usage, want) {
+		t.Fatalf("section: usage:\n%v\nwant suffix:\n%v", usage, want)
+	}
+}
+
+func TestTranslator(t *testing.T) {
+	r := New("app", "the app")
+	r.HandleGroup("db", "manage database", func(*struct {
+		Name string `long:"name" desc:"the name" dft:"x" deprecated:"use --dsn"`
+		DSN  string `long:"dsn" section:"Database" required:"true"`
+	}) {
+	})
+	r.SetTranslator(func(key, msg string) string {
+		switch {
+		case strings.HasPrefix(key, "cmd.") || strings.HasPrefix(key, "flag."):
+			return strings.ToUpper(msg)
+		case key == "section.Database":
+			return "Datenbank"
+		case key == "flagrouter.default":
+			return "Standard"
+		case key == "flagrouter.required_option":
+			return "flagrouter: Option %v ist erforderlich"
+		case key == "flagrouter.deprecated_option":
+			return "flagrouter: Warnung: Option %v ist veraltet: %v"
+		}
+		return msg
+	})
+
+	usage, err := r.Run(context.Background(), "-h")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("translator: run: %v", err)
+	}
+	if !strings.HasPrefix(usage, "app - THE APP\n") || !strings.Contains(usage, "  db\n    MANAGE DATABASE") {
+		t.Fatalf("translator: root usage:\n%v", usage)
+	}
+
+	usage, _ = r.Run(context.Background(), "db", "-h")
+	for _, s := range []string{
+		"app db - MANAGE DATABASE\n",
+		"--name string (Standard: \"x\")\n    THE NAME\n",
+		"Datenbank:\n  --dsn string",
+	} {
+		if !strings.Contains(usage, s) {
+			t.Fatalf("translator: usage does not contain %q:\n%v", s, usage)
+		}
+	}
+
+	var buf strings.Builder
+	r.SetOutput(&buf)
+	_, err = r.Run(context.Background(), "db", "--name", "y")
+	if err == nil || err.Error() != "flagrouter: Option --dsn ist erforderlich" {
+		t.Fatalf("translator: error: %v", err)
+	}
+	if buf.String() != "flagrouter: Warnung: Option --name ist veraltet: use --dsn\n" {
+		t.Fatalf("translator: warning: %q", buf.String())
+	}
+}
+
+func TestPositionalHelp(t *testing.T) {
+	type copyArgs struct {
+		Force bool     `short:"f"`
+		Dst   string   `pos:"2" desc:"destination directory"`
+		Srcs  []string `pos:"3" desc:"more files"`
+		Src   string   `pos:"1" placeholder:"FILE" desc:"source file"`
+	}
+
+	var got copyArgs
+	r := New("cp", "")
+	r.Handle(func(opt copyArgs) { got = opt })
+
+	usage, err := r.Run(context.Background(), "-h")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("positional help: run: %v", err)
+	}
+	want := "Usage:\n  cp [option] FILE DST SRCS...\n\n" +
+		"Arguments:\n  FILE\n    source file\n\n  DST\n    destination directory\n\n  SRCS...\n    more files\n\n" +
+		"Options:\n  -f bool"
+	if !strings.HasSuffix(usage, want) {
+		t.Fatalf("positional help: usage:\n%v\nwant suffix:\n%v", usage, want)
+	}
+
+	if _, err = r.Run(context.Background(), "a", "b", "c", "d"); err != nil {
+		t.Fatalf("positional help: run: %v", err)
+	}
+	if got.Src != "a" || got.Dst != "b" || strings.Join(got.Srcs, ",") != "c,d" {
+		t.Fatalf("positional help: %+v", got)
+	}
+
+	for _, c := range []struct {
+		handler any
+		err     string
+	}{
+		{func(*struct {
+			A string `pos:"1"`
+			B string `pos:"3"`
+		}) {
+		}, "positions must be 1 to 2, missing 2"},
+		{func(*struct {
+			A string `pos:"1"`
+			B string
+		}) {
+		}, "missing 2"},
+		{func(*struct {
+			A string `pos:"1"`
+			B string `pos:"1"`
+		}) {
+		}, "1 is taken by field A"},
+		{func(*struct {
+			A string `pos:"0"`
+		}) {
+		}, "invalid pos tag"},
+		{func(*struct {
+			A string `long:"a" pos:"1"`
+		}) {
+		}, "not a positional argument"},
+	} {
+		func() {
+			defer func() {
+				if e := recover(); e == nil || !strings.Contains(fmt.Sprint(e), c.err) {
+					t.Fatalf("positional help: expect panic %q, got %v", c.err, e)
+				}
+			}()
+			New("cp", "").Handle(c.handler)
+		}()
+	}
+}
+
+func TestDefaultAnnotation(t *testing.T) {
+	type defaults struct {
+		Name    string `long:"name" dft:"app"`
+		Prefix  string `long:"prefix" dft:""`
+		Suffix  string `long:"suffix"`
+		Count   int    `long:"count" dft:"0"`
+		Retries int    `long:"retries" dft:"3"`
+		Token   string `long:"token" dft:"abc" required:"true"`
+		Port    int    `long:"port" required:"true"`
+	}
+
+	r := New("app", "")
+	r.Handle(func(*defaults) {})
+
+	usage, err := r.Run(context.Background(), "-h")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("default annotation: run: %v", err)
+	}
+	for _, c := range []struct {
+		line string
+		want bool
+	}{
+		{"--name string (default: \"app\")\n", true},
+		{"--prefix string (default: \"\")\n", true},
+		{"--suffix string\n", true},
+		{"--count int\n", true},
+		{"--retries int (default: 3)\n", true},
+		{"--token string\n", true},
+		{"--port int", true},
+		{"--port int (default", false},
+		{"--suffix string (default", false},
+		{"--count int (default", false},
+		{"--token string (default", false},
+	} {
+		if strings.Contains(usage, c.line) != c.want {
+			t.Fatalf("default annotation: %q in usage: %v, want %v:\n%v", c.line, !c.want, c.want, usage)
+		}
+	}
+}
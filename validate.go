@@ -0,0 +1,377 @@
+package flagrouter
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var transforms = map[string]func(string) string{
+	"trim":  strings.TrimSpace,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"title": title,
+}
+
+// title upper cases the first letter of each word.
+func title(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	start := true
+	for _, c := range s {
+		if start && unicode.IsLetter(c) {
+			c = unicode.ToTitle(c)
+		}
+		start = unicode.IsSpace(c)
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// parseTransformTag parses tag like `transform:"trim,lower"`,
+// which is only allowed on string or []string fields.
+func parseTransformTag(field reflect.StructField) ([]func(string) string, error) {
+	tag := field.Tag.Get("transform")
+	if tag == "" {
+		return nil, nil
+	}
+	typ := field.Type
+	if typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.String {
+		return nil, fmt.Errorf("transform tag: unsupported type: %v", field.Type)
+	}
+
+	var fns []func(string) string
+	for _, name := range strings.Split(tag, ",") {
+		fn := transforms[strings.TrimSpace(name)]
+		if fn == nil {
+			return nil, fmt.Errorf("transform tag: unknown transform %q", name)
+		}
+		fns = append(fns, fn)
+	}
+	return fns, nil
+}
+
+// applyTransforms applies transforms to string params in order.
+func (st *state) applyTransforms() {
+	for _, p := range st.node.params {
+		if len(p.transforms) == 0 {
+			continue
+		}
+		val := reflect.ValueOf(p.ptr).Elem()
+		if val.Kind() == reflect.String {
+			val.SetString(p.transform(val.String()))
+			continue
+		}
+		// make a new slice, val may share elements with the default
+		ls := reflect.MakeSlice(val.Type(), val.Len(), val.Len())
+		for i := 0; i < val.Len(); i++ {
+			ls.Index(i).SetString(p.transform(val.Index(i).String()))
+		}
+		val.Set(ls)
+	}
+}
+
+// parseSetTags parses tags `dedup` and `sort` of field,
+// which must be a slice, or a map of slices.
+func parseSetTags(field reflect.StructField) (dedup, sorted bool, err error) {
+	if dedup, err = parseBoolTag(field, "dedup"); err != nil {
+		return
+	}
+	if sorted, err = parseBoolTag(field, "sort"); err != nil {
+		return
+	}
+	if !dedup && !sorted {
+		return
+	}
+	typ := field.Type
+	if typ.Kind() == reflect.Map {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Slice {
+		return false, false, fmt.Errorf("dedup or sort tag: unsupported type: %v", field.Type)
+	}
+	if dedup && !typ.Elem().Comparable() {
+		return false, false, fmt.Errorf("dedup tag: incomparable type: %v", typ.Elem())
+	}
+	if sorted && !ordered(typ.Elem()) {
+		return false, false, fmt.Errorf("sort tag: unordered type: %v", typ.Elem())
+	}
+	return
+}
+
+func ordered(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return true
+	}
+	return false
+}
+
+// applySets removes duplicated elements and sorts elements of params
+// tagged `dedup:"true"` and `sort:"true"`.
+func (st *state) applySets() {
+	for _, p := range st.node.params {
+		if p.dedup || p.sorted {
+			val := reflect.ValueOf(p.ptr).Elem()
+			val.Set(p.normalize(val))
+		}
+	}
+}
+
+// normalize returns a copy of slice val, or map val of slices,
+// with duplicated elements removed if p.dedup, and sorted if p.sorted.
+// The first one of duplicated elements is kept.
+func (p *param) normalize(val reflect.Value) reflect.Value {
+	if val.IsNil() {
+		return val
+	}
+	if val.Kind() == reflect.Map {
+		m := reflect.MakeMapWithSize(val.Type(), val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(iter.Key(), p.normalize(iter.Value()))
+		}
+		return m
+	}
+
+	ls := reflect.MakeSlice(val.Type(), 0, val.Len())
+	seen := make(map[any]bool)
+	for i := 0; i < val.Len(); i++ {
+		e := val.Index(i)
+		if p.dedup {
+			if seen[e.Interface()] {
+				continue
+			}
+			seen[e.Interface()] = true
+		}
+		ls = reflect.Append(ls, e)
+	}
+	if p.sorted {
+		sort.SliceStable(ls.Interface(), func(i, j int) bool {
+			return compareOrdered(ls.Index(i), ls.Index(j)) < 0
+		})
+	}
+	return ls
+}
+
+func compareOrdered(a, b reflect.Value) int {
+	if a.Kind() == reflect.String {
+		return cmp.Compare(a.String(), b.String())
+	}
+	return compareNumber(a, b)
+}
+
+func (p *param) transform(s string) string {
+	for _, fn := range p.transforms {
+		s = fn(s)
+	}
+	return s
+}
+
+// check validates value of a param.
+type check func(val reflect.Value) error
+
+// parseCheckTags parses validation tags of field:
+// `min`, `max`, `minlen`, `maxlen` and `pattern`.
+func parseCheckTags(field reflect.StructField, sep []string) ([]check, error) {
+	var checks []check
+	for _, name := range []string{"min", "max", "minlen", "maxlen", "pattern"} {
+		tag := field.Tag.Get(name)
+		if tag == "" {
+			continue
+		}
+		var c check
+		var err error
+		switch name {
+		case "min", "max":
+			c, err = boundCheck(field.Type, name, tag, sep)
+		case "minlen", "maxlen":
+			c, err = lenCheck(field.Type, name, tag)
+		case "pattern":
+			c, err = patternCheck(field.Type, tag)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v tag: %w", name, err)
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+// boundCheck returns a check for numeric value, or each element of a slice,
+// which must not be less than min or greater than max.
+func boundCheck(typ reflect.Type, name, tag string, sep []string) (check, error) {
+	if typ.Kind() == reflect.Slice {
+		elem, err := boundCheck(typ.Elem(), name, tag, sep)
+		if err != nil {
+			return nil, err
+		}
+		return eachElem(elem), nil
+	}
+
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+	default:
+		return nil, fmt.Errorf("unsupported type: %v", typ)
+	}
+
+	x, err := parseDefault(typ, tag, sep...)
+	if err != nil {
+		return nil, err
+	}
+	bound := reflect.ValueOf(x)
+	return func(val reflect.Value) error {
+		c := compareNumber(val, bound)
+		if name == "min" && c < 0 {
+			return fmt.Errorf("value %v is less than min %v", val, tag)
+		}
+		if name == "max" && c > 0 {
+			return fmt.Errorf("value %v is greater than max %v", val, tag)
+		}
+		return nil
+	}, nil
+}
+
+// eachElem returns a check applies elem to each element of a slice.
+func eachElem(elem check) check {
+	return func(val reflect.Value) error {
+		for i := 0; i < val.Len(); i++ {
+			if err := elem(val.Index(i)); err != nil {
+				return fmt.Errorf("index %v: %w", i, err)
+			}
+		}
+		return nil
+	}
+}
+
+// lenCheck returns a check for length of string, slice or map,
+// length of string is counted in runes rather than bytes.
+func lenCheck(typ reflect.Type, name, tag string) (check, error) {
+	switch typ.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+	default:
+		return nil, fmt.Errorf("unsupported type: %v", typ)
+	}
+	n, err := strconv.Atoi(tag)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("negative length %v", n)
+	}
+	return func(val reflect.Value) error {
+		l := val.Len()
+		if val.Kind() == reflect.String {
+			l = utf8.RuneCountInString(val.String())
+		}
+		if name == "minlen" && l < n {
+			return fmt.Errorf("length %v is less than minlen %v", l, n)
+		}
+		if name == "maxlen" && l > n {
+			return fmt.Errorf("length %v is greater than maxlen %v", l, n)
+		}
+		return nil
+	}, nil
+}
+
+// patternCheck returns a check for string, or each element of []string,
+// which must match regular expression tag.
+func patternCheck(typ reflect.Type, tag string) (check, error) {
+	if typ.Kind() == reflect.Slice {
+		elem, err := patternCheck(typ.Elem(), tag)
+		if err != nil {
+			return nil, err
+		}
+		return eachElem(elem), nil
+	}
+
+	if typ.Kind() != reflect.String {
+		return nil, fmt.Errorf("unsupported type: %v", typ)
+	}
+	re, err := regexp.Compile(tag)
+	if err != nil {
+		return nil, err
+	}
+	return func(val reflect.Value) error {
+		if !re.MatchString(val.String()) {
+			return fmt.Errorf("value %q does not match pattern %q", val.String(), tag)
+		}
+		return nil
+	}, nil
+}
+
+// compareNumber compares a and b of the same kind family,
+// b is returned by parseDefault.
+func compareNumber(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp.Compare(a.Uint(), b.Uint())
+	default:
+		return cmp.Compare(a.Float(), b.Float())
+	}
+}
+
+// RegisterValidator registers a named validator, which is used by fields
+// tagged like `validate:"name"` registered after it. Validators run after
+// args parsed and before handlers, with values of fields, and a non-nil error
+// aborts the run. Registering an existing name replaces the former one.
+func (r *Router) RegisterValidator(name string, fn func(any) error) {
+	if r.validators == nil {
+		r.validators = make(map[string]func(any) error)
+	}
+	r.validators[name] = fn
+	r.record(func(c *Router) { c.RegisterValidator(name, fn) })
+}
+
+// parseValidateTag appends validators named by validate tag of field to checks.
+// Validators are separated by comma, and run in order.
+func (r *Router) parseValidateTag(field reflect.StructField, checks []check) ([]check, error) {
+	tag := field.Tag.Get("validate")
+	if tag == "" {
+		return checks, nil
+	}
+	for _, name := range strings.Split(tag, ",") {
+		fn, ok := r.validators[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("validate tag: unknown validator %q", name)
+		}
+		checks = append(checks, func(val reflect.Value) error {
+			return fn(val.Interface())
+		})
+	}
+	return checks, nil
+}
+
+func (p *param) check(val reflect.Value) error {
+	for _, c := range p.checks {
+		if err := c(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks values of all params of the command.
+func (st *state) validate() error {
+	for _, p := range st.node.params {
+		if err := p.check(reflect.ValueOf(p.ptr).Elem()); err != nil {
+			return fmt.Errorf("flagrouter: field %v: %w", p.field, err)
+		}
+	}
+	return nil
+}
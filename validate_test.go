@@ -0,0 +1,298 @@
+package flagrouter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransform(t *testing.T) {
+	type transformed struct {
+		Trim  string   `long:"trim" transform:"trim"`
+		Lower string   `long:"lower" transform:"lower"`
+		Chain string   `long:"chain" transform:"trim,lower"`
+		Title string   `long:"title" transform:"title"`
+		Tags  []string `long:"tag" dft:"A,B" transform:"upper"`
+	}
+
+	var opt *transformed
+	r := New("transform", "")
+	r.Handle(func(o *transformed) { opt = o })
+
+	_, err := r.Run(context.Background(), "--trim", " a b ", "--lower", "AbC",
+		"--chain", "  FOO ", "--title", "hello wORLD", "--tag", "x", "--tag", "y")
+	if err != nil {
+		t.Fatalf("transform: run: %v", err)
+	}
+	want := transformed{Trim: "a b", Lower: "abc", Chain: "foo", Title: "Hello WORLD", Tags: []string{"X", "Y"}}
+	if !reflect.DeepEqual(*opt, want) {
+		t.Fatalf("transform: %+v, want %+v", *opt, want)
+	}
+
+	if _, err = r.Run(context.Background()); err != nil {
+		t.Fatalf("transform: run with default: %v", err)
+	}
+	if !reflect.DeepEqual(opt.Tags, []string{"A", "B"}) {
+		t.Fatalf("transform: default tags: %q", opt.Tags)
+	}
+}
+
+func TestTransformInvalid(t *testing.T) {
+	for name, fn := range map[string]any{
+		"int": func(struct {
+			N int `long:"n" transform:"trim"`
+		}) {
+		},
+		"unknown": func(struct {
+			S string `long:"s" transform:"trim,reverse"`
+		}) {
+		},
+	} {
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				if err == nil || !strings.Contains(err.Error(), "transform tag") {
+					t.Fatalf("transform %v: expect registration error, got %v", name, err)
+				}
+			}()
+			New("transform", "").Handle(fn)
+		}()
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	type bounded struct {
+		Port    int           `long:"port" dft:"80" min:"1" max:"65535"`
+		Ratio   float64       `long:"ratio" min:"0" max:"1"`
+		Timeout time.Duration `long:"timeout" dft:"1s" max:"1m"`
+		Sizes   []uint        `long:"size" max:"10"`
+	}
+
+	r := New("bound", "")
+	r.Handle(func(*bounded) {})
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{nil, ""},
+		{[]string{"--port", "65535", "--ratio", "0.5", "--size", "10"}, ""},
+		{[]string{"--port", "0"}, "field Port: value 0 is less than min 1"},
+		{[]string{"--port", "65536"}, "field Port: value 65536 is greater than max 65535"},
+		{[]string{"--ratio", "1.5"}, "field Ratio: value 1.5 is greater than max 1"},
+		{[]string{"--timeout", "2m"}, "field Timeout: value 2m0s is greater than max 1m"},
+		{[]string{"--size", "1", "--size", "11"}, "field Sizes: index 1: value 11 is greater than max 10"},
+	} {
+		_, err := r.Run(context.Background(), c.args...)
+		if c.err == "" && err != nil {
+			t.Fatalf("bound %q: %v", c.args, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Fatalf("bound %q: %v, want %v", c.args, err, c.err)
+		}
+	}
+}
+
+func TestMinMaxInvalid(t *testing.T) {
+	for name, fn := range map[string]any{
+		"default": func(struct {
+			N int `long:"n" dft:"0" min:"1"`
+		}) {
+		},
+		"string": func(struct {
+			S string `long:"s" max:"1"`
+		}) {
+		},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("bound %v: expect registration error", name)
+				}
+			}()
+			New("bound", "").Handle(fn)
+		}()
+	}
+}
+
+func TestMinMaxLen(t *testing.T) {
+	type lengths struct {
+		Name  string            `long:"name" minlen:"1" maxlen:"3"`
+		Tags  []string          `long:"tag" maxlen:"2"`
+		Attrs map[string]string `long:"attr" minlen:"1" dft:"a:1"`
+	}
+
+	r := New("length", "")
+	r.Handle(func(*lengths) {})
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--name", "abc"}, ""},
+		{[]string{"--name", "中文字"}, ""},
+		{nil, "field Name: length 0 is less than minlen 1"},
+		{[]string{"--name", "abcd"}, "field Name: length 4 is greater than maxlen 3"},
+		{[]string{"--name", "a", "--tag", "x", "--tag", "y", "--tag", "z"}, "field Tags: length 3 is greater than maxlen 2"},
+	} {
+		_, err := r.Run(context.Background(), c.args...)
+		if c.err == "" && err != nil {
+			t.Fatalf("length %q: %v", c.args, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Fatalf("length %q: %v, want %v", c.args, err, c.err)
+		}
+	}
+}
+
+func TestPattern(t *testing.T) {
+	type patterned struct {
+		Name  string   `long:"name" dft:"app" pattern:"^[a-z0-9-]+$"`
+		Hosts []string `long:"host" pattern:"^[a-z.]+$"`
+	}
+
+	r := New("pattern", "")
+	r.Handle(func(*patterned) {})
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{nil, ""},
+		{[]string{"--name", "my-app-2", "--host", "a.com", "--host", "b.org"}, ""},
+		{[]string{"--name", "My_App"}, `field Name: value "My_App" does not match pattern "^[a-z0-9-]+$"`},
+		{[]string{"--host", "a.com", "--host", "b:80"}, `field Hosts: index 1: value "b:80" does not match pattern "^[a-z.]+$"`},
+	} {
+		_, err := r.Run(context.Background(), c.args...)
+		if c.err == "" && err != nil {
+			t.Fatalf("pattern %q: %v", c.args, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Fatalf("pattern %q: %v, want %v", c.args, err, c.err)
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("pattern: expect registration error for bad pattern")
+			}
+		}()
+		New("pattern", "").Handle(func(struct {
+			S string `long:"s" pattern:"[a-"`
+		}) {
+		})
+	}()
+}
+
+func TestValidator(t *testing.T) {
+	errNoAt := errors.New("missing @")
+	r := New("validator", "")
+	r.RegisterValidator("email", func(v any) error {
+		if !strings.Contains(v.(string), "@") {
+			return errNoAt
+		}
+		return nil
+	})
+	r.RegisterValidator("nonempty", func(v any) error {
+		if len(v.([]string)) == 0 {
+			return errors.New("empty")
+		}
+		return nil
+	})
+
+	var called int
+	r.Handle(func(*struct {
+		Email string   `long:"email" dft:"a@b.c" validate:"email"`
+		Tags  []string `long:"tag" dft:"x" validate:"nonempty"`
+	}) {
+		called++
+	})
+
+	if _, err := r.Run(context.Background(), "--email", "me@example.com"); err != nil {
+		t.Fatalf("validator: run: %v", err)
+	}
+	_, err := r.Run(context.Background(), "--email", "me")
+	if !errors.Is(err, errNoAt) || !strings.Contains(err.Error(), "field Email") {
+		t.Fatalf("validator: invalid email: %v", err)
+	}
+	if called != 1 {
+		t.Fatalf("validator: handler called %v times", called)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("validator: expect registration error for unknown validator")
+			}
+		}()
+		r.HandleGroup("sub", "", func(struct {
+			S string `long:"s" validate:"phone"`
+		}) {
+		})
+	}()
+}
+
+func TestDedupSort(t *testing.T) {
+	type setOptions struct {
+		ML    map[string][]int `long:"ml" dft:"a:3,a:1,a:3"`
+		Dedup map[string][]int `long:"dedup" dft:"a:3,a:1,a:3" dedup:"true"`
+		Set   map[string][]int `long:"set" dft:"a:3,a:1,a:3" dedup:"true" sort:"true"`
+		Tags  []string         `long:"tag" sort:"true" transform:"lower"`
+	}
+	var got setOptions
+	r := New("set", "")
+	r.Handle(func(opt setOptions) { got = opt })
+
+	ctx := context.Background()
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("dedup sort: run: %v", err)
+	}
+	want := setOptions{
+		ML:    map[string][]int{"a": {3, 1, 3}},
+		Dedup: map[string][]int{"a": {3, 1}},
+		Set:   map[string][]int{"a": {1, 3}},
+		Tags:  got.Tags,
+	}
+	if !reflect.DeepEqual(got, want) || len(got.Tags) != 0 {
+		t.Fatalf("dedup sort: defaults: %+v", got)
+	}
+
+	_, err := r.Run(ctx, "--set", "b:2,a:5", "--set", "b:2,b:1,a:5", "--dedup", "x:2,x:1", "--dedup", "x:2",
+		"--tag", "b", "--tag", "C", "--tag", "a")
+	if err != nil {
+		t.Fatalf("dedup sort: run: %v", err)
+	}
+	want = setOptions{
+		ML:    map[string][]int{"a": {3, 1, 3}},
+		Dedup: map[string][]int{"x": {2, 1}},
+		Set:   map[string][]int{"a": {5}, "b": {1, 2}},
+		Tags:  []string{"a", "b", "c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("dedup sort: args: %+v", got)
+	}
+
+	for name, handler := range map[string]any{
+		"scalar": func(struct {
+			N int `long:"n" dedup:"true"`
+		}) {
+		},
+		"unordered": func(struct {
+			B []bool `long:"b" sort:"true"`
+		}) {
+		},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("dedup sort: %v: expect registration error", name)
+				}
+			}()
+			New("set", "").Handle(handler)
+		}()
+	}
+}
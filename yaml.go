@@ -0,0 +1,138 @@
+package flagrouter
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeYAML decodes the first document of data by gopkg.in/yaml.v3,
+// the top level of which must be a mapping.
+//
+// Mappings are decoded as map[string]any, sequences as []any,
+// and all scalars are kept as they are written, except null as nil,
+// to be converted by parseDefault later.
+func decodeYAML(data []byte) (map[string]any, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return map[string]any{}, nil
+	}
+
+	top := doc.Content[0]
+	d := &yamlDecoder{aliases: make(map[*yaml.Node]any)}
+	v, err := d.value(top)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return map[string]any{}, nil
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("yaml: line %v: top level must be a mapping", top.Line)
+	}
+	return m, nil
+}
+
+// yamlDecoder converts nodes to values. Values of anchors are converted
+// once and shared by their aliases.
+type yamlDecoder struct {
+	aliases map[*yaml.Node]any
+}
+
+var errYAMLRecursive = errors.New("anchor contains itself")
+
+func (d *yamlDecoder) value(n *yaml.Node) (any, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		v, ok := d.aliases[n.Alias]
+		if ok && v == errYAMLRecursive {
+			return nil, fmt.Errorf("yaml: line %v: %w", n.Line, errYAMLRecursive)
+		}
+		if ok {
+			return v, nil
+		}
+		d.aliases[n.Alias] = errYAMLRecursive
+		v, err := d.value(n.Alias)
+		if err != nil {
+			return nil, err
+		}
+		d.aliases[n.Alias] = v
+		return v, nil
+
+	case yaml.ScalarNode:
+		if n.ShortTag() == "!!null" {
+			return nil, nil
+		}
+		return n.Value, nil
+
+	case yaml.SequenceNode:
+		ls := make([]any, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := d.value(c)
+			if err != nil {
+				return nil, err
+			}
+			ls = append(ls, v)
+		}
+		return ls, nil
+
+	case yaml.MappingNode:
+		return d.mapping(n)
+	}
+	return nil, fmt.Errorf("yaml: line %v: unsupported node", n.Line)
+}
+
+// mapping converts a mapping node, keys of which must be scalars.
+// Keys merged by `<<` never override keys of the mapping itself,
+// and earlier merged mappings override later ones.
+func (d *yamlDecoder) mapping(n *yaml.Node) (map[string]any, error) {
+	m := make(map[string]any, len(n.Content)/2)
+	var merges []*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if k.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("yaml: line %v: keys must be scalars", k.Line)
+		}
+		if k.ShortTag() == "!!merge" {
+			if v.Kind == yaml.SequenceNode {
+				merges = append(merges, v.Content...)
+			} else {
+				merges = append(merges, v)
+			}
+			continue
+		}
+		val, err := d.value(v)
+		if err != nil {
+			return nil, err
+		}
+		m[k.Value] = val
+	}
+
+	merged := make(map[string]any)
+	for _, v := range merges {
+		val, err := d.value(v)
+		if err != nil {
+			return nil, err
+		}
+		mm, ok := val.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("yaml: line %v: merged value must be a mapping", v.Line)
+		}
+		for k, x := range mm {
+			if _, ok := merged[k]; !ok {
+				merged[k] = x
+			}
+		}
+	}
+	for k, x := range merged {
+		if _, ok := m[k]; !ok {
+			m[k] = x
+		}
+	}
+	return m, nil
+}
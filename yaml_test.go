@@ -0,0 +1,76 @@
+package flagrouter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	m, err := decodeYAML([]byte(`---
+str: hello world # comment
+quoted: "a # b"
+single: 'it''s'
+null:
+list:
+  - 1
+  - "2"
+nested:
+  key: value
+  inner:
+    - a: 1
+      b: 2
+    - [x, y]
+flow: {a: [1, 2], b: {c: d}}
+time: 2024-01-02T15:04:05
+mask: 0o755
+base: &base {x: 1, y: 2}
+merged:
+  <<: *base
+  y: 3
+alias: *base
+`))
+	if err != nil {
+		t.Fatalf("decode yaml: %v", err)
+	}
+
+	want := map[string]any{
+		"str":    "hello world",
+		"quoted": "a # b",
+		"single": "it's",
+		"null":   nil,
+		"list":   []any{"1", "2"},
+		"nested": map[string]any{
+			"key": "value",
+			"inner": []any{
+				map[string]any{"a": "1", "b": "2"},
+				[]any{"x", "y"},
+			},
+		},
+		"flow": map[string]any{
+			"a": []any{"1", "2"},
+			"b": map[string]any{"c": "d"},
+		},
+		"time":   "2024-01-02T15:04:05",
+		"mask":   "0o755",
+		"base":   map[string]any{"x": "1", "y": "2"},
+		"merged": map[string]any{"x": "1", "y": "3"},
+		"alias":  map[string]any{"x": "1", "y": "2"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("decode yaml: %#v", m)
+	}
+
+	for _, bad := range []string{
+		"- a\n- b\n",
+		"a: 1\n  b: 2\n",
+		"a: [1, 2\n",
+		"a: \"b\n",
+		"a: &x [*x]\n",
+		"a: {<<: [1]}\n",
+		"[a]: 1\n",
+	} {
+		if _, err = decodeYAML([]byte(bad)); err == nil {
+			t.Fatalf("decode yaml %q: no error", bad)
+		}
+	}
+}